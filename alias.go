@@ -0,0 +1,103 @@
+package mqttc
+
+import (
+	"container/list"
+
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// topicAliases assigns MQTT 5 topic aliases to outbound publishes. Once all
+// aliases the broker allows are in use, the least recently used topic gives
+// its alias up to the new one.
+type topicAliases struct {
+	max     uint16
+	byTopic map[string]*list.Element
+	lru     *list.List // of *aliasEntry, most recently used at the front
+}
+
+type aliasEntry struct {
+	topic string
+	alias uint16
+}
+
+func newTopicAliases(max uint16) *topicAliases {
+	return &topicAliases{
+		max:     max,
+		byTopic: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// alias returns the alias to use for topic and whether the broker already
+// knows it. When it does not, the publish must carry the topic as well.
+func (a *topicAliases) alias(topic string) (uint16, bool) {
+	if e, ok := a.byTopic[topic]; ok {
+		a.lru.MoveToFront(e)
+		return e.Value.(*aliasEntry).alias, true
+	}
+
+	if a.lru.Len() < int(a.max) {
+		entry := &aliasEntry{topic: topic, alias: uint16(a.lru.Len() + 1)}
+		a.byTopic[topic] = a.lru.PushFront(entry)
+		return entry.alias, false
+	}
+
+	// reuse the alias of the least recently used topic
+	e := a.lru.Back()
+	entry := e.Value.(*aliasEntry)
+	delete(a.byTopic, entry.topic)
+	entry.topic = topic
+	a.byTopic[topic] = e
+	a.lru.MoveToFront(e)
+	return entry.alias, false
+}
+
+// peek returns what alias would return, without handing out or reusing an alias
+func (a *topicAliases) peek(topic string) (uint16, bool) {
+	if e, ok := a.byTopic[topic]; ok {
+		return e.Value.(*aliasEntry).alias, true
+	}
+	if a.lru.Len() < int(a.max) {
+		return uint16(a.lru.Len() + 1), false
+	}
+	return a.lru.Back().Value.(*aliasEntry).alias, false
+}
+
+// withAlias returns a copy of publish that uses the topic alias peek
+// suggests, leaving the caller's packet and the aliases untouched; call
+// alias once the copy is actually sent
+func (a *topicAliases) withAlias(publish *packets.PublishPacket) *packets.PublishPacket {
+	alias, known := a.peek(publish.Topic)
+
+	out := *publish
+	props := packets.Properties{}
+	if publish.Properties != nil {
+		props = *publish.Properties
+	}
+	props.TopicAlias = &alias
+	out.Properties = &props
+	if known {
+		out.Topic = ""
+	}
+	return &out
+}
+
+// resolveAlias replaces the topic alias of an inbound publish by its topic,
+// remembering new aliases the broker sets up. It reports false when the
+// publish uses an alias the broker never set up.
+func resolveAlias(aliases map[uint16]string, max uint16, publish *packets.PublishPacket) bool {
+	if publish.Properties == nil || publish.Properties.TopicAlias == nil {
+		return publish.Topic != ""
+	}
+	alias := *publish.Properties.TopicAlias
+	if alias == 0 || alias > max {
+		return false
+	}
+	if publish.Topic != "" {
+		aliases[alias] = publish.Topic
+		return true
+	}
+	topic, ok := aliases[alias]
+	publish.Topic = topic
+	return ok
+}
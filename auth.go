@@ -0,0 +1,138 @@
+package mqttc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// Authenticator drives an MQTT 5 enhanced authentication exchange, see
+// WithAuthenticator. An exchange starts with Start, goes through any number
+// of Continue round trips and ends with Finish; the same Authenticator is
+// used again from Start when the client reconnects or re-authenticates.
+type Authenticator interface {
+	// Method is the Authentication Method, e.g. "SCRAM-SHA-256".
+	Method() string
+	// Start returns the initial Authentication Data sent in CONNECT or in
+	// the AUTH packet starting a re-authentication.
+	Start() ([]byte, error)
+	// Continue answers a challenge from the broker.
+	Continue(challenge []byte) ([]byte, error)
+	// Finish checks the Authentication Data the broker sent along with
+	// its success; an error aborts the connection.
+	Finish(data []byte) error
+}
+
+// ErrReauthInProgress is returned by Reauthenticate while an earlier
+// re-authentication has not completed yet.
+var ErrReauthInProgress = errors.New("re-authentication already in progress")
+
+// authPacket builds an AUTH packet carrying the authentication method and data
+func (c *Client) authPacket(reason byte, data []byte) []byte {
+	return packets.EncodeAuth(&packets.AuthPacket{
+		ReasonCode: reason,
+		Properties: &packets.Properties{
+			AuthenticationMethod: c.authenticator.Method(),
+			AuthenticationData:   data,
+		},
+	})
+}
+
+// handleAuth takes the next step of an exchange after the broker sent AUTH,
+// reporting whether the exchange has completed successfully
+func (c *Client) handleAuth(data []byte) (bool, error) {
+	if c.authenticator == nil {
+		return false, errors.New("broker sent AUTH but no authenticator is set")
+	}
+	auth, err := packets.DecodeAuth(data)
+	if err != nil {
+		return false, err
+	}
+
+	var method string
+	var authData []byte
+	if auth.Properties != nil {
+		method, authData = auth.Properties.AuthenticationMethod, auth.Properties.AuthenticationData
+	}
+	if method != c.authenticator.Method() {
+		return false, fmt.Errorf("broker switched authentication method to %q", method)
+	}
+
+	switch auth.ReasonCode {
+	case packets.ReasonContinueAuthentication:
+		answer, err := c.authenticator.Continue(authData)
+		if err != nil {
+			return false, err
+		}
+		return false, c.write(c.authPacket(packets.ReasonContinueAuthentication, answer))
+	case packets.ReasonSuccess:
+		return true, c.authenticator.Finish(authData)
+	default:
+		return false, fmt.Errorf("unexpected AUTH reason code 0x%02X", auth.ReasonCode)
+	}
+}
+
+// Reauthenticate runs the authentication exchange again on the open
+// connection, for instance to present fresh credentials before the old ones
+// expire. It blocks until the broker accepted them; if it refuses, the broker
+// closes the connection and Reauthenticate reports why.
+func (c *Client) Reauthenticate() error {
+	if c.ProtocolVersion() != packets.V5 || c.authenticator == nil {
+		return errors.New("re-authentication needs MQTT 5 and an authenticator")
+	}
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+
+	c.mu.Lock()
+	if c.reauth != nil {
+		c.mu.Unlock()
+		return ErrReauthInProgress
+	}
+	result := make(chan error, 1)
+	c.reauth = result
+	done := c.done
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.reauth = nil
+		c.mu.Unlock()
+	}()
+
+	data, err := c.authenticator.Start()
+	if err != nil {
+		return err
+	}
+	if err := c.write(c.authPacket(packets.ReasonReAuthenticate, data)); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-done:
+		// readLoop reports a DISCONNECT before it ends the connection
+		select {
+		case err := <-result:
+			return err
+		default:
+			return ErrNotConnected
+		}
+	}
+}
+
+// finishReauth hands the outcome of a re-authentication to Reauthenticate
+func (c *Client) finishReauth(err error) {
+	c.mu.Lock()
+	result := c.reauth
+	c.mu.Unlock()
+	if result == nil {
+		return
+	}
+	select {
+	case result <- err:
+	default:
+	}
+}
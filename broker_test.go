@@ -0,0 +1,485 @@
+package mqttc_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc/packets"
+	"github.com/gorunriki/mqttc/topic"
+)
+
+// fakeBroker is a minimal in-process MQTT 3.1.1 / 5 broker used to drive the client in tests.
+// It accepts any CONNECT, grants every subscription and routes publishes to matching subscribers,
+// answering each session in the protocol version it connected with.
+type fakeBroker struct {
+	t  *testing.T
+	ln net.Listener
+
+	// set before the client connects
+	ackDelay     time.Duration         // wait this long before acknowledging a publish
+	holdAcks     bool                  // keep publish acks back until releaseAcks
+	pubackReason byte                  // MQTT 5 reason code put on PUBACK and PUBREC
+	connack      packets.ConnackPacket // template for the CONNACK answer
+	authMethod   string                // enhanced authentication method we accept
+	newAuth      func() brokerAuth     // starts an exchange of authMethod
+	versions     []byte                // protocol levels we accept, all if empty
+	refuseV5     byte                  // MQTT 5 reason code refusing V5 if not accepted, else we answer like a 3.1.1 broker
+
+	mu         sync.Mutex
+	sessions   map[*brokerSession]bool
+	received   []byte // packet types in the order they arrived
+	connects   []*packets.ConnectPacket
+	subscribes []*packets.SubscribePacket
+	publishes  []*packets.PublishPacket // as they arrived, before topic aliases are resolved
+	held       []func()                 // acks kept back by holdAcks
+	shareNext  map[string]int           // round robin position of each shared subscription
+	accepted   int                      // connections accepted so far
+	wg         sync.WaitGroup
+}
+
+// brokerAuth takes one step of an enhanced authentication exchange, returning
+// the data to send back and whether the client is authenticated
+type brokerAuth func(data []byte) (reply []byte, done bool, err error)
+
+// brokerSub is a subscription with the identifier it was made with, 0 if none
+type brokerSub struct {
+	packets.Subscription
+	id int
+}
+
+type brokerSession struct {
+	seq     int // order in which sessions were accepted
+	conn    net.Conn
+	writeMu sync.Mutex
+	version byte
+	subs    []brokerSub
+	nextID  uint16 // last packet ID used for a QoS 1/2 publish to the client, guarded by fakeBroker.mu
+
+	aliasMax  uint16            // topic aliases the client lets us use
+	inAliases map[uint16]string // topic aliases set up by the client
+	outAlias  map[string]uint16 // topic aliases we set up for the client
+
+	auth       brokerAuth // running authentication exchange
+	connected  bool       // CONNACK has been sent
+	assignedID string     // client ID we made up for a client that sent none
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	b := &fakeBroker{t: t, ln: ln, sessions: make(map[*brokerSession]bool), shareNext: make(map[string]int)}
+	b.wg.Add(1)
+	go b.accept()
+	t.Cleanup(b.close)
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return b.ln.Addr().String()
+}
+
+func (b *fakeBroker) close() {
+	b.ln.Close()
+	b.mu.Lock()
+	for s := range b.sessions {
+		s.conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// count returns how many packets of the given type the broker has received
+func (b *fakeBroker) count(packetType byte) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, pt := range b.received {
+		if pt == packetType {
+			n++
+		}
+	}
+	return n
+}
+
+func (b *fakeBroker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.accepted++
+		s := &brokerSession{seq: b.accepted, conn: conn}
+		b.sessions[s] = true
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go b.serve(s)
+	}
+}
+
+func (b *fakeBroker) serve(s *brokerSession) {
+	defer b.wg.Done()
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, s)
+		b.mu.Unlock()
+		s.conn.Close()
+	}()
+
+	r := bufio.NewReader(s.conn)
+	for {
+		data, err := packets.ReadPacket(r)
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.received = append(b.received, packets.Type(data))
+		b.mu.Unlock()
+
+		switch packets.Type(data) {
+		case packets.CONNECT:
+			connect, err := packets.DecodeConnect(data)
+			if err != nil {
+				b.t.Errorf("broker: decode CONNECT: %v", err)
+				return
+			}
+			if len(b.versions) > 0 && !slices.Contains(b.versions, connect.ProtocolVersion) {
+				b.mu.Lock()
+				b.connects = append(b.connects, connect)
+				b.mu.Unlock()
+				refusal := &packets.ConnackPacket{Version: packets.V311, ReturnCode: packets.UnacceptableProtocolVersion}
+				if connect.ProtocolVersion == packets.V5 && b.refuseV5 != 0 {
+					refusal = &packets.ConnackPacket{Version: packets.V5, ReturnCode: b.refuseV5}
+				}
+				s.write(packets.EncodeConnack(refusal))
+				return
+			}
+			s.version = connect.ProtocolVersion
+			s.inAliases = make(map[uint16]string)
+			s.outAlias = make(map[string]uint16)
+			if connect.Properties != nil && connect.Properties.TopicAliasMaximum != nil {
+				s.aliasMax = *connect.Properties.TopicAliasMaximum
+			}
+			b.mu.Lock()
+			b.connects = append(b.connects, connect)
+			if connect.ClientID == "" && s.version == packets.V5 {
+				s.assignedID = fmt.Sprintf("auto%d", len(b.connects))
+			}
+			b.mu.Unlock()
+			if connect.Properties != nil && connect.Properties.AuthenticationMethod != "" {
+				if connect.Properties.AuthenticationMethod != b.authMethod {
+					s.write(packets.EncodeConnack(&packets.ConnackPacket{Version: s.version, ReturnCode: packets.ReasonBadAuthenticationMethod}))
+					return
+				}
+				s.auth = b.newAuth()
+				if !b.authenticate(s, connect.Properties.AuthenticationData) {
+					return
+				}
+				continue
+			}
+			b.sendConnack(s, nil)
+		case packets.AUTH:
+			auth, err := packets.DecodeAuth(data)
+			if err != nil || auth.Properties == nil || s.auth == nil {
+				b.t.Errorf("broker: unexpected AUTH: %v", err)
+				return
+			}
+			if auth.ReasonCode == packets.ReasonReAuthenticate {
+				s.auth = b.newAuth()
+			}
+			if !b.authenticate(s, auth.Properties.AuthenticationData) {
+				return
+			}
+		case packets.SUBSCRIBE:
+			sub, err := packets.DecodeSubscribe(data, s.version)
+			if err != nil {
+				b.t.Errorf("broker: decode SUBSCRIBE: %v", err)
+				return
+			}
+			suback := &packets.SubackPacket{Version: s.version, PacketID: sub.PacketID}
+			id := 0
+			if sub.Properties != nil && len(sub.Properties.SubscriptionIdentifiers) > 0 {
+				id = sub.Properties.SubscriptionIdentifiers[0]
+			}
+			b.mu.Lock()
+			b.subscribes = append(b.subscribes, sub)
+			for _, f := range sub.Topics {
+				s.subs = append(s.subs, brokerSub{Subscription: f, id: id})
+				suback.ReturnCodes = append(suback.ReturnCodes, f.QoS)
+			}
+			b.mu.Unlock()
+			s.write(packets.EncodeSuback(suback))
+		case packets.UNSUBSCRIBE:
+			unsub, err := packets.DecodeUnsubscribe(data, s.version)
+			if err != nil {
+				b.t.Errorf("broker: decode UNSUBSCRIBE: %v", err)
+				return
+			}
+			unsuback := &packets.UnsubackPacket{Version: s.version, PacketID: unsub.PacketID}
+			b.mu.Lock()
+			for _, filter := range unsub.Topics {
+				code := packets.ReasonNoSubscriptionExisted
+				for i, sub := range s.subs {
+					if sub.Topic == filter {
+						s.subs = append(s.subs[:i], s.subs[i+1:]...)
+						code = packets.ReasonSuccess
+						break
+					}
+				}
+				if s.version == packets.V5 {
+					unsuback.ReasonCodes = append(unsuback.ReasonCodes, code)
+				}
+			}
+			b.mu.Unlock()
+			s.write(packets.EncodeUnsuback(unsuback))
+		case packets.PUBLISH:
+			pub, err := packets.DecodePublish(data, s.version)
+			if err != nil {
+				b.t.Errorf("broker: decode PUBLISH: %v", err)
+				return
+			}
+			raw := *pub
+			b.mu.Lock()
+			b.publishes = append(b.publishes, &raw)
+			b.mu.Unlock()
+			if pub.Properties != nil && pub.Properties.TopicAlias != nil {
+				alias := *pub.Properties.TopicAlias
+				if pub.Topic != "" {
+					s.inAliases[alias] = pub.Topic
+				} else if pub.Topic = s.inAliases[alias]; pub.Topic == "" {
+					b.t.Errorf("broker: unknown topic alias %d", alias)
+					return
+				}
+			}
+			if pub.QoS > 0 {
+				time.Sleep(b.ackDelay)
+				ack := &packets.AckPacket{Version: s.version, PacketType: packets.PUBACK, PacketID: pub.PacketID, ReasonCode: b.pubackReason}
+				if pub.QoS == 2 {
+					ack.PacketType = packets.PUBREC
+				}
+				b.mu.Lock()
+				if b.holdAcks {
+					b.held = append(b.held, func() { s.write(packets.EncodeAck(ack)) })
+				} else {
+					s.write(packets.EncodeAck(ack))
+				}
+				b.mu.Unlock()
+			}
+			b.route(s, pub)
+		case packets.PUBREL:
+			ack, err := packets.DecodeAck(data, s.version)
+			if err != nil {
+				b.t.Errorf("broker: decode PUBREL: %v", err)
+				return
+			}
+			s.write(packets.EncodeAck(&packets.AckPacket{Version: s.version, PacketType: packets.PUBCOMP, PacketID: ack.PacketID}))
+		case packets.PUBREC:
+			// the client received our QoS 2 publish
+			ack, err := packets.DecodeAck(data, s.version)
+			if err != nil {
+				b.t.Errorf("broker: decode PUBREC: %v", err)
+				return
+			}
+			s.write(packets.EncodeAck(&packets.AckPacket{Version: s.version, PacketType: packets.PUBREL, PacketID: ack.PacketID}))
+		case packets.PINGREQ:
+			s.write(packets.EncodePingresp())
+		case packets.DISCONNECT:
+			return
+		}
+	}
+}
+
+// sendConnack answers CONNECT with the connack template
+func (b *fakeBroker) sendConnack(s *brokerSession, props *packets.Properties) {
+	connack := b.connack
+	connack.Version = s.version
+	if props != nil {
+		connack.Properties = props
+	}
+	if s.assignedID != "" {
+		assigned := packets.Properties{}
+		if connack.Properties != nil {
+			assigned = *connack.Properties
+		}
+		assigned.AssignedClientIdentifier = s.assignedID
+		connack.Properties = &assigned
+	}
+	s.connected = true
+	s.write(packets.EncodeConnack(&connack))
+}
+
+// authenticate runs the next step of the session's authentication exchange
+// and reports whether the session may go on
+func (b *fakeBroker) authenticate(s *brokerSession, data []byte) bool {
+	reply, done, err := s.auth(data)
+	switch {
+	case err != nil && !s.connected:
+		s.write(packets.EncodeConnack(&packets.ConnackPacket{Version: s.version, ReturnCode: packets.ReasonNotAuthorized}))
+		return false
+	case err != nil:
+		s.write(packets.EncodeDisconnectPacket(&packets.DisconnectPacket{Version: s.version, ReasonCode: packets.ReasonNotAuthorized}))
+		return false
+	case !done:
+		s.write(packets.EncodeAuth(&packets.AuthPacket{
+			ReasonCode: packets.ReasonContinueAuthentication,
+			Properties: &packets.Properties{AuthenticationMethod: b.authMethod, AuthenticationData: reply},
+		}))
+	case !s.connected:
+		b.sendConnack(s, &packets.Properties{AuthenticationMethod: b.authMethod, AuthenticationData: reply})
+	default:
+		s.write(packets.EncodeAuth(&packets.AuthPacket{
+			ReasonCode: packets.ReasonSuccess,
+			Properties: &packets.Properties{AuthenticationMethod: b.authMethod, AuthenticationData: reply},
+		}))
+	}
+	return true
+}
+
+// route forwards a publish from sender as QoS 0 to every session with a matching
+// subscription, honouring No Local and passing on subscription identifiers.
+// Members of a shared subscription take turns in the order they connected.
+func (b *fakeBroker) route(sender *brokerSession, pub *packets.PublishPacket) {
+	type member struct {
+		s   *brokerSession
+		id  int
+		qos byte
+	}
+	type target struct {
+		ids []int
+		qos byte
+	}
+	b.mu.Lock()
+	targets := make(map[*brokerSession]*target)
+	add := func(m member) {
+		t := targets[m.s]
+		if t == nil {
+			t = &target{}
+			targets[m.s] = t
+		}
+		if m.id != 0 {
+			t.ids = append(t.ids, m.id)
+		}
+		// overlapping subscriptions get the message once, with the highest QoS
+		t.qos = max(t.qos, min(m.qos, pub.QoS))
+	}
+	shared := make(map[string][]member)
+	for s := range b.sessions {
+		for _, sub := range s.subs {
+			if !topic.MatchTopic(sub.Topic, pub.Topic) || sub.NoLocal && s == sender {
+				continue
+			}
+			if _, _, ok := topic.ParseShared(sub.Topic); ok {
+				shared[sub.Topic] = append(shared[sub.Topic], member{s, sub.id, sub.QoS})
+				continue
+			}
+			add(member{s, sub.id, sub.QoS})
+		}
+	}
+	for filter, members := range shared {
+		slices.SortFunc(members, func(a, b member) int { return a.s.seq - b.s.seq })
+		add(members[b.shareNext[filter]%len(members)])
+		b.shareNext[filter]++
+	}
+	packetIDs := make(map[*brokerSession]uint16)
+	for s, t := range targets {
+		if t.qos > 0 {
+			s.nextID++
+			packetIDs[s] = s.nextID
+		}
+	}
+	b.mu.Unlock()
+
+	for s, t := range targets {
+		props := packets.Properties{}
+		if pub.Properties != nil {
+			props = *pub.Properties
+		}
+		props.SubscriptionIdentifiers = t.ids
+		out := &packets.PublishPacket{
+			Version:    s.version,
+			QoS:        t.qos,
+			PacketID:   packetIDs[s],
+			Topic:      pub.Topic,
+			Properties: &props,
+			Payload:    pub.Payload,
+		}
+		s.writePublish(out)
+	}
+}
+
+// releaseAcks sends the acks kept back so far; later ones are still held
+func (b *fakeBroker) releaseAcks() {
+	b.mu.Lock()
+	held := b.held
+	b.held = nil
+	b.mu.Unlock()
+	for _, send := range held {
+		send()
+	}
+}
+
+// lastConnect returns the most recent CONNECT the broker received
+func (b *fakeBroker) lastConnect() *packets.ConnectPacket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.connects) == 0 {
+		return nil
+	}
+	return b.connects[len(b.connects)-1]
+}
+
+// kick sends an MQTT 5 DISCONNECT with reason to every session and drops it
+func (b *fakeBroker) kick(reason byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.sessions {
+		s.write(packets.EncodeDisconnectPacket(&packets.DisconnectPacket{Version: s.version, ReasonCode: reason}))
+		s.conn.Close()
+	}
+}
+
+// writePublish gives each new topic the next alias until the client's maximum is reached
+func (s *brokerSession) writePublish(pub *packets.PublishPacket) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	props := pub.Properties
+	props.TopicAlias = nil
+
+	if alias, ok := s.outAlias[pub.Topic]; ok {
+		props.TopicAlias = &alias
+		pub.Topic = ""
+	} else if len(s.outAlias) < int(s.aliasMax) {
+		alias := uint16(len(s.outAlias) + 1)
+		s.outAlias[pub.Topic] = alias
+		props.TopicAlias = &alias
+	}
+	s.conn.Write(packets.EncodePublish(pub))
+}
+
+func (s *brokerSession) write(data []byte) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.Write(data)
+}
+
+// waitFor polls cond until it holds or fails the test after two seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 2s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,130 @@
+package mqttc
+
+import (
+	"errors"
+	"sync"
+)
+
+// Overflow decides what happens to a message when the channel or queue it
+// should go to is full.
+type Overflow int
+
+const (
+	// Block waits for room, holding up every later message until the consumer catches up.
+	Block Overflow = iota
+	// DropOldest discards the oldest queued message to make room.
+	DropOldest
+	// DropNewest discards the message that does not fit.
+	DropNewest
+)
+
+// chanSub is the channel of a SubscribeChan
+type chanSub struct {
+	overflow Overflow
+	ch       chan Message
+	canceled chan struct{} // closed by cancel to release a blocked send
+
+	mu     sync.Mutex // held while sending, so ch is never closed under a sender
+	closed bool
+}
+
+// SubscribeChan subscribes to filter and returns a channel receiving the
+// matching messages, buffered for bufSize of them. When the buffer is full,
+// overflow decides whether delivery waits for the consumer or which message
+// is dropped; drops are counted in Stats. Messages that go to a channel are not passed to the message
+// handler.
+//
+// The channel stays open across reconnects until cancel is called, which
+// closes it and unsubscribes from filter unless another SubscribeChan still
+// uses the same filter. When the broker does not resume the session on
+// reconnect, Connect subscribes to filter again.
+func (c *Client) SubscribeChan(filter string, qos byte, bufSize int, overflow Overflow) (<-chan Message, func() error, error) {
+	return c.subscribeChan(filter, bufSize, overflow, SubscribeQoS(qos))
+}
+
+// subscribeChan is SubscribeChan with the full set of subscribe options
+func (c *Client) subscribeChan(filter string, bufSize int, overflow Overflow, opts ...SubscribeOption) (<-chan Message, func() error, error) {
+	if bufSize < 0 {
+		return nil, nil, errors.New("negative channel buffer size")
+	}
+	sub := &chanSub{
+		overflow: overflow,
+		ch:       make(chan Message, bufSize),
+		canceled: make(chan struct{}),
+	}
+	cancel, err := c.addRoute(&route{
+		filter: filter,
+		deliver: func(msg Message, done <-chan struct{}) {
+			if !sub.send(msg, done) {
+				c.droppedIncoming.Add(1)
+				c.logger.Warn("subscription channel full, message dropped", "filter", filter, "topic", msg.Topic)
+			}
+		},
+		stop: sub.close,
+	}, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sub.ch, cancel, nil
+}
+
+// close closes the channel, waiting for a send in progress to give up.
+// Messages still buffered will never be read, so they are acknowledged.
+func (s *chanSub) close() {
+	close(s.canceled)
+	s.mu.Lock()
+	s.closed = true
+	close(s.ch)
+	s.mu.Unlock()
+	for msg := range s.ch {
+		msg.Ack()
+	}
+}
+
+// send puts msg on the channel according to the overflow policy and reports
+// whether no message had to be dropped
+func (s *chanSub) send(msg Message, done <-chan struct{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		msg.Ack()
+		return true
+	}
+
+	select {
+	case s.ch <- msg:
+		return true
+	default:
+	}
+
+	// a dropped message never reaches the application, so nobody else acknowledges it
+	switch s.overflow {
+	case DropNewest:
+		msg.Ack()
+		return false
+	case DropOldest:
+		for {
+			select {
+			case old := <-s.ch:
+				old.Ack()
+			default:
+			}
+			select {
+			case s.ch <- msg:
+				return false
+			default: // the consumer took the free slot first, try again
+			}
+		}
+	default:
+		// once the connection is gone, nothing may hold up Disconnect
+		select {
+		case s.ch <- msg:
+			return true
+		case <-s.canceled:
+			msg.Ack()
+			return true
+		case <-done:
+			return false
+		}
+	}
+}
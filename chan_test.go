@@ -0,0 +1,323 @@
+package mqttc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// flush publishes a message to the message handler and waits for it, so that
+// everything published before has been dispatched
+func flush(t *testing.T, client *mqttc.Client, handled <-chan string) {
+	t.Helper()
+	if err := client.Publish("flush", "x"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the message handler")
+	}
+}
+
+func TestSubscribeChan(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "chansub")
+	defer client.Disconnect()
+
+	handled := make(chan string, 10)
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		handled <- topic
+	})
+	if err := client.Subscribe("flush"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	msgs, cancel, err := client.SubscribeChan("sensors/+", 1, 10, mqttc.Block)
+	if err != nil {
+		t.Fatalf("SubscribeChan: %v", err)
+	}
+	if err := client.Publish("sensors/a", "21", mqttc.PublishQoS(1)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case msg := <-msgs:
+		if msg.Topic != "sensors/a" || string(msg.Payload) != "21" || msg.QoS != 1 {
+			t.Errorf("received %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	flush(t, client, handled)
+	if len(handled) != 0 {
+		t.Errorf("message handler also got %q", <-handled)
+	}
+
+	if err := cancel(); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if n := b.count(packets.UNSUBSCRIBE); n != 1 {
+		t.Errorf("broker received %d UNSUBSCRIBE packets; want 1", n)
+	}
+	if _, ok := <-msgs; ok {
+		t.Error("channel still open after cancel")
+	}
+	if err := cancel(); err != nil {
+		t.Errorf("second cancel: %v", err)
+	}
+}
+
+func TestSubscribeChanSharedFilter(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "chanshared")
+	defer client.Disconnect()
+
+	first, cancelFirst, err := client.SubscribeChan("a/#", 0, 1, mqttc.Block)
+	if err != nil {
+		t.Fatalf("SubscribeChan: %v", err)
+	}
+	second, cancelSecond, err := client.SubscribeChan("a/#", 0, 1, mqttc.Block)
+	if err != nil {
+		t.Fatalf("SubscribeChan: %v", err)
+	}
+
+	// the broker sends one copy per matching subscription, both channels get each
+	if err := client.Publish("a/b", "x"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	for _, ch := range []<-chan mqttc.Message{first, second} {
+		select {
+		case <-ch:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+
+	// the filter stays subscribed while the second channel still uses it
+	cancelFirst()
+	if n := b.count(packets.UNSUBSCRIBE); n != 0 {
+		t.Errorf("broker received %d UNSUBSCRIBE packets; want 0", n)
+	}
+	cancelSecond()
+	if n := b.count(packets.UNSUBSCRIBE); n != 1 {
+		t.Errorf("broker received %d UNSUBSCRIBE packets; want 1", n)
+	}
+}
+
+func TestSubscribeChanOverflow(t *testing.T) {
+	tests := []struct {
+		overflow mqttc.Overflow
+		want     string
+	}{
+		{mqttc.DropNewest, "0"},
+		{mqttc.DropOldest, "2"},
+	}
+	for _, tc := range tests {
+		b := newFakeBroker(t)
+		client := connect(t, b, "overflow")
+
+		handled := make(chan string, 10)
+		client.SetMessageHandler(func(topic string, payload []byte) {
+			handled <- topic
+		})
+		if err := client.Subscribe("flush"); err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		msgs, cancel, err := client.SubscribeChan("queue", 0, 1, tc.overflow)
+		if err != nil {
+			t.Fatalf("SubscribeChan: %v", err)
+		}
+
+		for _, payload := range []string{"0", "1", "2"} {
+			if err := client.Publish("queue", payload); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+		}
+		flush(t, client, handled)
+		if got := string((<-msgs).Payload); got != tc.want {
+			t.Errorf("overflow %d: received %q; want %q", tc.overflow, got, tc.want)
+		}
+		if len(msgs) != 0 {
+			t.Errorf("overflow %d: %d more messages queued; want 0", tc.overflow, len(msgs))
+		}
+		if got := client.Stats().Dropped; got != 2 {
+			t.Errorf("overflow %d: Stats().Dropped = %d; want 2", tc.overflow, got)
+		}
+		cancel()
+		client.Disconnect()
+	}
+}
+
+func TestSubscribeChanBlock(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	client := connect(t, b, "block")
+
+	msgs, cancel, err := client.SubscribeChan("queue", 1, 1, mqttc.Block)
+	if err != nil {
+		t.Fatalf("SubscribeChan: %v", err)
+	}
+	for _, payload := range []string{"0", "1", "2"} {
+		if err := client.Publish("queue", payload, mqttc.PublishQoS(1)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	// nothing is lost, the consumer just holds up delivery
+	for _, want := range []string{"0", "1", "2"} {
+		select {
+		case msg := <-msgs:
+			if string(msg.Payload) != want {
+				t.Errorf("received %q; want %q", msg.Payload, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+
+	// a delivery blocked on a full channel does not hold up Disconnect
+	for _, payload := range []string{"3", "4"} {
+		if err := client.Publish("queue", payload); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	waitFor(t, func() bool { return len(msgs) == 1 })
+	client.Disconnect()
+	cancel()
+}
+
+func TestSubscribeChanNegativeBuffer(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "negative")
+	defer client.Disconnect()
+
+	if _, _, err := client.SubscribeChan("a", 0, -1, mqttc.Block); err == nil {
+		t.Error("SubscribeChan with a negative buffer size succeeded")
+	}
+	if n := b.count(packets.SUBSCRIBE); n != 0 {
+		t.Errorf("broker received %d SUBSCRIBE packets; want 0", n)
+	}
+}
+
+func TestSubscribeChanCancelAcks(t *testing.T) {
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "cancelacks", mqttc.WithHandlerMode(mqttc.PerTopic, mqttc.AckManual))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	// one message buffered, one blocked on the full channel, one still queued
+	msgs, cancel, err := client.SubscribeChan("jobs", 1, 1, mqttc.Block)
+	if err != nil {
+		t.Fatalf("SubscribeChan: %v", err)
+	}
+	for _, payload := range []string{"0", "1", "2"} {
+		if err := client.Publish("jobs", payload, mqttc.PublishQoS(1)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	waitFor(t, func() bool { return len(msgs) == 1 })
+	if n := b.count(packets.PUBACK); n != 0 {
+		t.Fatalf("broker has %d PUBACKs before cancel; want 0", n)
+	}
+
+	// none of them reaches the application, so the client acknowledges them all
+	if err := cancel(); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	waitFor(t, func() bool { return b.count(packets.PUBACK) == 3 })
+}
+
+func TestMessagesBreakAcks(t *testing.T) {
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "breakacks", mqttc.WithHandlerMode(mqttc.PerTopic, mqttc.AckManual))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	go func() {
+		for b.count(packets.SUBSCRIBE) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		for _, payload := range []string{"0", "1", "2"} {
+			client.Publish("jobs", payload, mqttc.PublishQoS(1))
+		}
+	}()
+	for msg, err := range client.Messages(context.Background(), "jobs", mqttc.SubscribeQoS(1)) {
+		if err != nil {
+			t.Fatalf("Messages: %v", err)
+		}
+		// wait for the rest to be buffered behind this one
+		waitFor(t, func() bool { return client.Stats().Queued == 0 && b.count(packets.PUBLISH) == 3 })
+		time.Sleep(20 * time.Millisecond)
+		msg.Ack()
+		break
+	}
+	waitFor(t, func() bool { return b.count(packets.PUBACK) == 3 })
+}
+
+func TestSubscribeChanReconnect(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	client := connect(t, b, "chanreconnect")
+	defer client.Close()
+
+	msgs, cancel, err := client.SubscribeChan("sensors/+", 1, 10, mqttc.Block)
+	if err != nil {
+		t.Fatalf("SubscribeChan: %v", err)
+	}
+	defer cancel()
+
+	// the fake broker never resumes a session, the subscription is gone with the connection
+	b.kick(packets.ReasonServerShuttingDown)
+	waitFor(t, func() bool { return !client.IsConnected() })
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	b.mu.Lock()
+	n, restored := len(b.subscribes), b.subscribes[len(b.subscribes)-1].Topics[0]
+	b.mu.Unlock()
+	if n != 2 || restored.Topic != "sensors/+" || restored.QoS != 1 {
+		t.Fatalf("broker got %d SUBSCRIBE packets, the last for %+v; want sensors/+ with QoS 1 again", n, restored)
+	}
+
+	if err := client.Publish("sensors/a", "21", mqttc.PublishQoS(1)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case msg := <-msgs:
+		if msg.Topic != "sensors/a" || string(msg.Payload) != "21" {
+			t.Errorf("received %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message after reconnect")
+	}
+}
+
+func TestSubscribeChanSessionPresent(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	b.connack.SessionPresent = true
+	client := connect(t, b, "chanresumed")
+	defer client.Close()
+
+	_, cancel, err := client.SubscribeChan("sensors/+", 1, 10, mqttc.Block)
+	if err != nil {
+		t.Fatalf("SubscribeChan: %v", err)
+	}
+	defer cancel()
+
+	b.kick(packets.ReasonServerShuttingDown)
+	waitFor(t, func() bool { return !client.IsConnected() })
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	// a resumed session still has the subscription
+	if n := b.count(packets.SUBSCRIBE); n != 1 {
+		t.Errorf("broker received %d SUBSCRIBE packets; want 1", n)
+	}
+}
@@ -0,0 +1,79 @@
+package mqttc
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// ErrInvalidClientID is returned by Connect for a client ID the broker is not
+// required to accept, see WithClientIDValidation.
+var ErrInvalidClientID = errors.New("invalid client ID")
+
+// clientIDChars are the characters every broker must accept in a client ID
+const clientIDChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// maxClientIDLen is the longest client ID every broker must accept
+const maxClientIDLen = 23
+
+// validateClientID checks the client ID sent in CONNECT for the given protocol
+// level. An empty ID asks the broker to assign one, which MQTT 3.1 does not
+// allow and MQTT 3.1.1 only together with a clean session.
+func (c *Client) validateClientID(id string, version byte) error {
+	if id == "" {
+		switch {
+		case version == packets.V31:
+			return fmt.Errorf("%w: MQTT 3.1 needs a client ID", ErrInvalidClientID)
+		case version == packets.V311 && !c.cleanSession:
+			return fmt.Errorf("%w: an empty client ID needs a clean session", ErrInvalidClientID)
+		}
+		return nil
+	}
+	if !c.validateClientIDs {
+		return nil
+	}
+	if len(id) > maxClientIDLen {
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidClientID, id, maxClientIDLen)
+	}
+	for _, r := range id {
+		if !isClientIDChar(r) {
+			return fmt.Errorf("%w: %q contains %q, only 0-9, a-z and A-Z are allowed", ErrInvalidClientID, id, r)
+		}
+	}
+	return nil
+}
+
+func isClientIDChar(r rune) bool {
+	return '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+// randomClientID returns prefix followed by random characters, at least 8 of
+// them and as many as fit into the 23 characters every broker accepts
+func randomClientID(prefix string) string {
+	n := max(maxClientIDLen-len(prefix), 8)
+	id := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(id) < n {
+		rand.Read(buf)
+		for _, b := range buf {
+			// bytes past the last whole multiple of len(clientIDChars) would favour the first characters
+			if int(b) < 256-256%len(clientIDChars) && len(id) < n {
+				id = append(id, clientIDChars[int(b)%len(clientIDChars)])
+			}
+		}
+	}
+	return prefix + string(id)
+}
+
+// ClientID returns the client ID of the session: the one passed to NewClient
+// or, if that was empty, the one an MQTT 5 broker assigned on Connect.
+func (c *Client) ClientID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clientID == "" {
+		return c.assignedID
+	}
+	return c.clientID
+}
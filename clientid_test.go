@@ -0,0 +1,92 @@
+package mqttc_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+)
+
+func TestClientIDValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		opts     []mqttc.Option
+		valid    bool
+	}{
+		{"alphanumeric", "sensor42", []mqttc.Option{mqttc.WithClientIDValidation(true)}, true},
+		{"23 characters", strings.Repeat("a", 23), []mqttc.Option{mqttc.WithClientIDValidation(true)}, true},
+		{"24 characters", strings.Repeat("a", 24), []mqttc.Option{mqttc.WithClientIDValidation(true)}, false},
+		{"dash", "my-client", []mqttc.Option{mqttc.WithClientIDValidation(true)}, false},
+		{"non ASCII", "clïent", []mqttc.Option{mqttc.WithClientIDValidation(true)}, false},
+		{"validation off by default", "my-client/with:anything-longer-than-23", nil, true},
+		{"empty with clean session", "", nil, true},
+		{"empty without clean session", "", []mqttc.Option{mqttc.WithCleanSession(false)}, false},
+		{"empty on MQTT 3.1", "", []mqttc.Option{mqttc.WithProtocolVersion(packets.V31)}, false},
+		{"empty on MQTT 5 without clean start", "", []mqttc.Option{mqttc.WithProtocolVersion(packets.V5), mqttc.WithCleanSession(false)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFakeBroker(t)
+			client := mqttc.NewClient(b.addr(), tt.clientID, tt.opts...)
+			err := client.Connect()
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("Connect = %v; want success", err)
+				}
+				client.Disconnect()
+				return
+			}
+			if !errors.Is(err, mqttc.ErrInvalidClientID) {
+				t.Fatalf("Connect = %v; want ErrInvalidClientID", err)
+			}
+			if b.lastConnect() != nil {
+				t.Error("CONNECT was sent for an invalid client ID")
+			}
+		})
+	}
+}
+
+func TestRandomClientID(t *testing.T) {
+	b := newFakeBroker(t)
+	seen := make(map[string]bool)
+	for range 10 {
+		client := mqttc.NewClient(b.addr(), "ignored", mqttc.WithRandomClientID("dev"))
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		client.Disconnect()
+
+		id := b.lastConnect().ClientID
+		if len(id) != 23 || !strings.HasPrefix(id, "dev") {
+			t.Errorf("random client ID %q; want 23 characters starting with dev", id)
+		}
+		if seen[id] {
+			t.Errorf("random client ID %q generated twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestV5AssignedClientID(t *testing.T) {
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "", mqttc.WithProtocolVersion(packets.V5), mqttc.WithCleanSession(false))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if got := client.ClientID(); got != "auto1" {
+		t.Errorf("ClientID = %q; want the assigned auto1", got)
+	}
+
+	// resuming the session needs the ID the broker assigned
+	client.Disconnect()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	defer client.Disconnect()
+	if got := b.lastConnect().ClientID; got != "auto1" {
+		t.Errorf("reconnect sent client ID %q; want auto1", got)
+	}
+}
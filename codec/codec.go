@@ -0,0 +1,75 @@
+// Package codec converts between Go values and MQTT payloads for the typed
+// subscriptions and publishers of the mqttc package.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes values into payloads and decodes payloads back into values.
+// Unmarshal is passed a pointer to the value to fill in.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSON encodes values with encoding/json.
+type JSON struct{}
+
+func (JSON) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSON) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// CBOR encodes values as CBOR (RFC 8949), a compact binary alternative to JSON.
+type CBOR struct{}
+
+func (CBOR) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (CBOR) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+// Protobuf encodes Protocol Buffers messages. Values must be proto.Message
+// implementations; Unmarshal also accepts a pointer to a message pointer,
+// such as a **pb.Reading, and allocates the message when it is nil.
+type Protobuf struct{}
+
+func (Protobuf) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T is not a protobuf message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (Protobuf) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	// a typed subscription to *pb.Reading decodes into a **pb.Reading
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() || ptr.Elem().Kind() != reflect.Pointer {
+		return fmt.Errorf("codec: %T is not a protobuf message", v)
+	}
+	elem := ptr.Elem()
+	if elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+	m, ok := elem.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("codec: %T is not a protobuf message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,63 @@
+package codec_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gorunriki/mqttc/codec"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type reading struct {
+	Sensor string  `json:"sensor" cbor:"sensor"`
+	Value  float64 `json:"value" cbor:"value"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, c := range map[string]codec.Codec{"JSON": codec.JSON{}, "CBOR": codec.CBOR{}} {
+		want := reading{Sensor: "t1", Value: 21.5}
+		data, err := c.Marshal(want)
+		if err != nil {
+			t.Fatalf("%s Marshal: %v", name, err)
+		}
+		var got reading
+		if err := c.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s Unmarshal: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s round trip = %+v; want %+v", name, got, want)
+		}
+		if err := c.Unmarshal([]byte{0xff, 0x00}, &got); err == nil {
+			t.Errorf("%s Unmarshal of garbage succeeded", name)
+		}
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	var c codec.Protobuf
+	data, err := c.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	// into a message
+	msg := &wrapperspb.StringValue{}
+	if err := c.Unmarshal(data, msg); err != nil || msg.GetValue() != "hello" {
+		t.Errorf("Unmarshal into a message = %q, %v; want hello", msg.GetValue(), err)
+	}
+
+	// into a nil message pointer, as SubscribeTyped[*wrapperspb.StringValue] does
+	var ptr *wrapperspb.StringValue
+	if err := c.Unmarshal(data, &ptr); err != nil || !proto.Equal(ptr, wrapperspb.String("hello")) {
+		t.Errorf("Unmarshal into a message pointer = %v, %v; want hello", ptr, err)
+	}
+
+	if _, err := c.Marshal("not a message"); err == nil {
+		t.Error("Marshal of a string succeeded")
+	}
+	var s string
+	if err := c.Unmarshal(data, &s); err == nil {
+		t.Error("Unmarshal into a string succeeded")
+	}
+}
@@ -2,4 +2,10 @@ module github.com/gorunriki/mqttc
 
 go 1.25.7
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require (
+	github.com/fxamacker/cbor/v2 v2.9.4
+	github.com/gorilla/websocket v1.5.3
+	google.golang.org/protobuf v1.36.12
+)
+
+require github.com/x448/float16 v0.8.4 // indirect
@@ -0,0 +1,62 @@
+package mqttc
+
+import (
+	"context"
+	"iter"
+)
+
+// messagesBuffer is how many messages Messages queues ahead of the loop body
+const messagesBuffer = 16
+
+// Messages returns an iterator over the messages matching filter:
+//
+//	for msg, err := range client.Messages(ctx, "sensors/#", mqttc.SubscribeQoS(1)) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The filter is subscribed with opts, as for Subscribe, when iteration
+// starts and unsubscribed when the loop ends. Messages are yielded in the
+// order they arrive; while the loop body runs, further deliveries wait. The
+// iteration ends with an error: the one of a failed subscribe, ErrNotConnected
+// once the connection is lost, or ctx.Err() when ctx ends.
+func (c *Client) Messages(ctx context.Context, filter string, opts ...SubscribeOption) iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		if err := ctx.Err(); err != nil {
+			yield(Message{}, err)
+			return
+		}
+		c.mu.Lock()
+		done := c.done
+		c.mu.Unlock()
+		msgs, cancel, err := c.subscribeChan(filter, messagesBuffer, Block, opts...)
+		if err != nil {
+			yield(Message{}, err)
+			return
+		}
+		defer cancel()
+
+		for {
+			select {
+			case msg := <-msgs:
+				if !yield(msg, nil) {
+					return
+				}
+			case <-done:
+				// hand out what arrived before the connection went away
+				for len(msgs) > 0 {
+					if !yield(<-msgs, nil) {
+						return
+					}
+				}
+				yield(Message{}, ErrNotConnected)
+				return
+			case <-ctx.Done():
+				yield(Message{}, ctx.Err())
+				return
+			}
+		}
+	}
+}
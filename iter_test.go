@@ -0,0 +1,105 @@
+package mqttc_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+	"github.com/gorunriki/mqttc/topic"
+)
+
+func TestMessages(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "iterator")
+	defer client.Disconnect()
+
+	go func() {
+		for b.count(packets.SUBSCRIBE) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		for i := range 5 {
+			client.Publish("numbers/"+strconv.Itoa(i), strconv.Itoa(i))
+		}
+	}()
+
+	var got []string
+	for msg, err := range client.Messages(context.Background(), "numbers/+") {
+		if err != nil {
+			t.Fatalf("Messages: %v", err)
+		}
+		got = append(got, string(msg.Payload))
+		if len(got) == 3 {
+			break
+		}
+	}
+	if want := []string{"0", "1", "2"}; !slices.Equal(got, want) {
+		t.Errorf("received %v; want %v", got, want)
+	}
+	if n := b.count(packets.UNSUBSCRIBE); n != 1 {
+		t.Errorf("broker received %d UNSUBSCRIBE packets after break; want 1", n)
+	}
+}
+
+func TestMessagesContext(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "iteratorctx")
+	defer client.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var last error
+	for _, err := range client.Messages(ctx, "quiet/#") {
+		last = err
+	}
+	if !errors.Is(last, context.DeadlineExceeded) {
+		t.Errorf("last error = %v; want context.DeadlineExceeded", last)
+	}
+	if n := b.count(packets.UNSUBSCRIBE); n != 1 {
+		t.Errorf("broker received %d UNSUBSCRIBE packets after the context ended; want 1", n)
+	}
+
+	// a failed subscribe ends the loop with its error
+	for _, err := range client.Messages(context.Background(), "bad/#/filter") {
+		if !errors.Is(err, topic.ErrInvalidFilter) {
+			t.Errorf("Messages with an invalid filter = %v; want ErrInvalidFilter", err)
+		}
+	}
+}
+
+func TestMessagesConnectionLost(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "iteratorlost")
+	defer client.Close()
+
+	go func() {
+		for b.count(packets.SUBSCRIBE) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		client.Publish("alerts/a", "first", mqttc.PublishQoS(1))
+	}()
+
+	var got []string
+	var last error
+	for msg, err := range client.Messages(context.Background(), "alerts/#", mqttc.SubscribeQoS(1)) {
+		if err != nil {
+			last = err
+			continue
+		}
+		got = append(got, string(msg.Payload))
+		if msg.QoS != 1 {
+			t.Errorf("message QoS = %d; want the subscribed 1", msg.QoS)
+		}
+		b.kick(packets.ReasonServerShuttingDown)
+	}
+	if !slices.Equal(got, []string{"first"}) {
+		t.Errorf("received %v; want [first]", got)
+	}
+	if !errors.Is(last, mqttc.ErrNotConnected) {
+		t.Errorf("last error = %v; want ErrNotConnected", last)
+	}
+}
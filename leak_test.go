@@ -0,0 +1,42 @@
+package mqttc_test
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientGoroutines returns the stacks of goroutines still running client code
+func clientGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	var leaked []string
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, "mqttc.(*Client)") {
+			leaked = append(leaked, g)
+		}
+	}
+	return leaked
+}
+
+// verifyNoLeaks fails the test if client goroutines are still alive once it
+// finishes, in the spirit of go.uber.org/goleak.
+func verifyNoLeaks(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			leaked := clientGoroutines()
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("%d client goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package mqttc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorunriki/mqttc/packets"
+	"github.com/gorunriki/mqttc/topic"
+)
+
+// Limits are the session parameters in effect for the current connection.
+// With MQTT 5 the broker may override what the client asked for in CONNACK;
+// with MQTT 3.1.1 they are simply the client's own settings.
+type Limits struct {
+	SessionExpiry     time.Duration // how long the broker keeps the session after disconnect
+	ReceiveMaximum    uint16        // QoS 1/2 publishes we may have in flight at once
+	MaximumPacketSize uint32        // largest packet the broker accepts, 0 means no limit
+	MaximumQoS        byte          // highest QoS the broker accepts on PUBLISH
+	RetainAvailable   bool          // whether the broker supports retained messages
+	KeepAlive         time.Duration // interval the client must stay active within, 0 disables it
+	TopicAliasMaximum uint16        // topic aliases we may use on PUBLISH, 0 means none
+
+	SharedSubscriptionAvailable     bool // whether the broker supports $share subscriptions
+	SubscriptionIdentifierAvailable bool // whether the broker supports subscription identifiers
+}
+
+// Limits returns the parameters negotiated on the most recent connection.
+func (c *Client) Limits() Limits {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limits
+}
+
+// connectProperties returns the MQTT 5 CONNECT properties for the configured options
+func (c *Client) connectProperties() *packets.Properties {
+	props := &packets.Properties{}
+	if c.sessionExpiry > 0 {
+		props.SessionExpiryInterval = ptr(uint32(c.sessionExpiry / time.Second))
+	}
+	if c.receiveMaximum > 0 {
+		props.ReceiveMaximum = ptr(c.receiveMaximum)
+	}
+	if c.maximumPacketSize > 0 {
+		props.MaximumPacketSize = ptr(c.maximumPacketSize)
+	}
+	if c.topicAliasMaximum > 0 {
+		props.TopicAliasMaximum = ptr(c.topicAliasMaximum)
+	}
+	return props
+}
+
+// negotiate works out the limits of a connection from our settings and the broker's CONNACK
+func (c *Client) negotiate(connack *packets.ConnackPacket) Limits {
+	limits := Limits{
+		SessionExpiry:   c.sessionExpiry,
+		ReceiveMaximum:  65535,
+		MaximumQoS:      2,
+		RetainAvailable: true,
+		KeepAlive:       c.keepAlivePeriod,
+
+		SharedSubscriptionAvailable:     true,
+		SubscriptionIdentifierAvailable: true,
+	}
+
+	props := connack.Properties
+	if c.ProtocolVersion() != packets.V5 || props == nil {
+		return limits
+	}
+	if props.SessionExpiryInterval != nil {
+		limits.SessionExpiry = time.Duration(*props.SessionExpiryInterval) * time.Second
+	}
+	if props.ReceiveMaximum != nil && *props.ReceiveMaximum > 0 {
+		limits.ReceiveMaximum = *props.ReceiveMaximum
+	}
+	if props.MaximumPacketSize != nil {
+		limits.MaximumPacketSize = *props.MaximumPacketSize
+	}
+	if props.MaximumQoS != nil {
+		limits.MaximumQoS = *props.MaximumQoS
+	}
+	if props.RetainAvailable != nil {
+		limits.RetainAvailable = *props.RetainAvailable != 0
+	}
+	if props.SharedSubscriptionAvailable != nil {
+		limits.SharedSubscriptionAvailable = *props.SharedSubscriptionAvailable != 0
+	}
+	if props.SubscriptionIdentifierAvailable != nil {
+		limits.SubscriptionIdentifierAvailable = *props.SubscriptionIdentifierAvailable != 0
+	}
+	if props.TopicAliasMaximum != nil {
+		limits.TopicAliasMaximum = *props.TopicAliasMaximum
+	}
+	if props.ServerKeepAlive != nil {
+		limits.KeepAlive = time.Duration(*props.ServerKeepAlive) * time.Second
+	}
+	return limits
+}
+
+// checkPublish rejects an invalid publish or one the broker told us it would not accept
+func (c *Client) checkPublish(publish *packets.PublishPacket, size int, limits Limits) error {
+	if err := topic.ValidateName(publish.Topic); err != nil {
+		return err
+	}
+	if publish.QoS > limits.MaximumQoS {
+		return ErrQoSNotSupported
+	}
+	if publish.Retain && !limits.RetainAvailable {
+		return ErrRetainNotSupported
+	}
+	if limits.MaximumPacketSize > 0 && size > int(limits.MaximumPacketSize) {
+		return ErrPacketTooLarge
+	}
+	return nil
+}
+
+// maxSubscriptionIdentifier is the largest subscription identifier, the
+// largest Variable Byte Integer
+const maxSubscriptionIdentifier = 268435455
+
+// checkSubscribe rejects an invalid subscription or one the broker told us it would not accept
+func (c *Client) checkSubscribe(sub packets.Subscription, props *packets.Properties) error {
+	if err := topic.ValidateFilter(sub.Topic); err != nil {
+		return err
+	}
+	if sub.RetainHandling > packets.RetainDoNotSend {
+		return fmt.Errorf("invalid retain handling %d", sub.RetainHandling)
+	}
+	if props != nil {
+		for _, id := range props.SubscriptionIdentifiers {
+			if id < 1 || id > maxSubscriptionIdentifier {
+				return fmt.Errorf("subscription identifier %d is not between 1 and %d", id, maxSubscriptionIdentifier)
+			}
+		}
+		c.mu.Lock()
+		available := c.limits.SubscriptionIdentifierAvailable
+		c.mu.Unlock()
+		if len(props.SubscriptionIdentifiers) > 0 && !available {
+			return ErrSubscriptionIDNotSupported
+		}
+	}
+	if _, _, shared := topic.ParseShared(sub.Topic); shared {
+		c.mu.Lock()
+		available := c.limits.SharedSubscriptionAvailable
+		c.mu.Unlock()
+		if !available {
+			return ErrSharedNotSupported
+		}
+		if sub.NoLocal && c.ProtocolVersion() == packets.V5 {
+			return errors.New("shared subscriptions cannot use No Local")
+		}
+	}
+	return nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
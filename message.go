@@ -0,0 +1,97 @@
+package mqttc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// Message is a message received from the broker. The fields below the
+// packet ID are only ever set on an MQTT 5 connection.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	PacketID uint16 // 0 for QoS 0
+
+	UserProperties          []packets.UserProperty
+	ContentType             string
+	PayloadFormat           byte          // 1 if the payload is UTF-8 text, 0 if unspecified
+	MessageExpiry           time.Duration // remaining lifetime, 0 if the message does not expire
+	ResponseTopic           string
+	CorrelationData         []byte
+	SubscriptionIdentifiers []int // identifiers of the subscriptions that matched
+
+	acker *acker // set for QoS 1 and 2 messages received with AckManual
+}
+
+// acker sends the acknowledgement of a message once the application asks for it
+type acker struct {
+	once    sync.Once
+	err     error
+	client  *Client
+	publish *packets.PublishPacket
+	done    <-chan struct{} // closed when the connection the message arrived on ends
+}
+
+// Ack acknowledges a QoS 1 or 2 message received with AckManual, telling the
+// broker it need not send the message again. Only the first call counts,
+// also across copies of the message. Once the connection the message
+// arrived on has ended, Ack returns ErrNotConnected and the broker sends the
+// message again. For any other message Ack does nothing.
+func (m Message) Ack() error {
+	if m.acker == nil {
+		return nil
+	}
+	a := m.acker
+	a.once.Do(func() {
+		select {
+		case <-a.done:
+			a.err = ErrNotConnected
+		default:
+			a.err = a.client.ack(a.publish)
+		}
+	})
+	return a.err
+}
+
+// Handler receives every message that is not a reply to a Request or a
+// request taken by Respond, see SetHandler.
+type Handler func(msg Message)
+
+// newMessage builds the Message handed to the application from a PUBLISH
+func newMessage(publish *packets.PublishPacket) Message {
+	msg := Message{
+		Topic:    publish.Topic,
+		Payload:  publish.Payload,
+		QoS:      publish.QoS,
+		Retain:   publish.Retain,
+		Dup:      publish.Dup,
+		PacketID: publish.PacketID,
+	}
+	if props := publish.Properties; props != nil {
+		msg.UserProperties = props.UserProperties
+		msg.ContentType = props.ContentType
+		if props.PayloadFormatIndicator != nil {
+			msg.PayloadFormat = *props.PayloadFormatIndicator
+		}
+		if props.MessageExpiryInterval != nil {
+			msg.MessageExpiry = time.Duration(*props.MessageExpiryInterval) * time.Second
+		}
+		msg.ResponseTopic = props.ResponseTopic
+		msg.CorrelationData = props.CorrelationData
+		msg.SubscriptionIdentifiers = props.SubscriptionIdentifiers
+	}
+	return msg
+}
+
+// SetHandler installs the function that receives incoming messages with all
+// their metadata. It replaces a handler set by SetMessageHandler.
+func (c *Client) SetHandler(handler Handler) {
+	c.mu.Lock()
+	c.handler = handler
+	c.mu.Unlock()
+}
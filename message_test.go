@@ -0,0 +1,202 @@
+package mqttc_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/codec"
+	"github.com/gorunriki/mqttc/packets"
+)
+
+func TestV5MessageMetadata(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connectV5(t, b, "metadata")
+	defer client.Disconnect()
+
+	received := make(chan mqttc.Message, 1)
+	client.SetHandler(func(msg mqttc.Message) {
+		received <- msg
+	})
+	if err := client.Subscribe("meta/#"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	err := client.Publish("meta/reading", `{"t":21.5}`,
+		mqttc.PublishQoS(1),
+		mqttc.PublishUserProperty("unit", "celsius"),
+		mqttc.PublishUserProperty("unit", "kelvin"),
+		mqttc.PublishContentType("application/json"),
+		mqttc.PublishPayloadFormat(1),
+		mqttc.PublishMessageExpiry(90*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	var msg mqttc.Message
+	select {
+	case msg = <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	want := mqttc.Message{
+		Topic:   "meta/reading",
+		Payload: []byte(`{"t":21.5}`),
+		UserProperties: []packets.UserProperty{
+			{Key: "unit", Value: "celsius"},
+			{Key: "unit", Value: "kelvin"},
+		},
+		ContentType:   "application/json",
+		PayloadFormat: 1,
+		MessageExpiry: 90 * time.Second,
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("received %+v; want %+v", msg, want)
+	}
+
+	// the broker sees the QoS the message was published with
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if pub := b.publishes[0]; pub.QoS != 1 || pub.PacketID == 0 {
+		t.Errorf("broker received QoS %d with packet ID %d; want QoS 1 with a packet ID", pub.QoS, pub.PacketID)
+	}
+}
+
+func TestSetMessageHandlerReplacesHandler(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "replace")
+	defer client.Disconnect()
+
+	client.SetHandler(func(msg mqttc.Message) {
+		t.Errorf("replaced handler got %q", msg.Topic)
+	})
+	received := make(chan string, 1)
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		received <- topic
+	})
+	if err := client.Subscribe("replace/#"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := client.Publish("replace/me", "x"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != "replace/me" {
+			t.Errorf("handler got %q; want replace/me", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestManualAck(t *testing.T) {
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "manualack", mqttc.WithHandlerMode(mqttc.PerTopic, mqttc.AckManual))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	received := make(chan mqttc.Message, 10)
+	client.SetHandler(func(msg mqttc.Message) {
+		received <- msg
+	})
+	if err := client.Subscribe("jobs/#", mqttc.SubscribeQoS(2)); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	next := func() mqttc.Message {
+		t.Helper()
+		select {
+		case msg := <-received:
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+		return mqttc.Message{}
+	}
+
+	for _, tc := range []struct {
+		qos     byte
+		ackType byte
+	}{
+		{1, packets.PUBACK},
+		{2, packets.PUBREC},
+	} {
+		if err := client.Publish("jobs/a", "work", mqttc.PublishQoS(tc.qos)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		msg := next()
+
+		// nothing is acknowledged until the application says so
+		time.Sleep(50 * time.Millisecond)
+		if n := b.count(tc.ackType); n != 0 {
+			t.Fatalf("QoS %d: broker has %d acks before Ack; want 0", tc.qos, n)
+		}
+		if err := msg.Ack(); err != nil {
+			t.Fatalf("QoS %d: Ack: %v", tc.qos, err)
+		}
+		if err := msg.Ack(); err != nil {
+			t.Errorf("QoS %d: second Ack: %v", tc.qos, err)
+		}
+		waitFor(t, func() bool { return b.count(tc.ackType) == 1 })
+	}
+	// the broker's PUBREL completes the QoS 2 flow
+	waitFor(t, func() bool { return b.count(packets.PUBCOMP) == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if n := b.count(packets.PUBACK); n != 1 {
+		t.Errorf("broker has %d PUBACKs after a repeated Ack; want 1", n)
+	}
+
+	// QoS 0 messages have nothing to acknowledge
+	if err := client.Publish("jobs/b", "fire and forget"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := next().Ack(); err != nil {
+		t.Errorf("Ack of a QoS 0 message: %v", err)
+	}
+
+	// once the connection is gone, the broker will send the message again
+	if err := client.Publish("jobs/c", "late", mqttc.PublishQoS(1)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	late := next()
+
+	// a MessageHandler has no message to acknowledge, so returning does it
+	client.SetMessageHandler(func(topic string, payload []byte) {})
+	before := b.count(packets.PUBACK)
+	if err := client.Publish("jobs/d", "legacy", mqttc.PublishQoS(1)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitFor(t, func() bool { return b.count(packets.PUBACK) == before+1 })
+
+	client.Disconnect()
+	if err := late.Ack(); !errors.Is(err, mqttc.ErrNotConnected) {
+		t.Errorf("Ack after Disconnect = %v; want ErrNotConnected", err)
+	}
+}
+
+func TestManualAckTyped(t *testing.T) {
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "manualtyped", mqttc.WithHandlerMode(mqttc.PerTopic, mqttc.AckManual))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	// a typed handler has no message to acknowledge, so returning does it
+	received := make(chan string, 1)
+	if _, err := mqttc.SubscribeTyped(client, "names", codec.JSON{}, func(topic string, name string) {
+		received <- name
+	}, mqttc.SubscribeQoS(1)); err != nil {
+		t.Fatalf("SubscribeTyped: %v", err)
+	}
+	if err := client.Publish("names", `"gopher"`, mqttc.PublishQoS(1)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	<-received
+	waitFor(t, func() bool { return b.count(packets.PUBACK) == 1 })
+}
@@ -1,18 +1,87 @@
 package mqttc
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorunriki/mqttc/packets"
+	"github.com/gorunriki/mqttc/topic"
 )
 
 var (
-	ErrNotConnected = errors.New("not connected to broker")
+	ErrNotConnected     = errors.New("not connected to broker")
+	ErrAlreadyConnected = errors.New("already connected to broker")
+	ErrInvalidQoS       = errors.New("QoS must be 0, 1 or 2")
+
+	// the broker announced in CONNACK that it does not accept these
+	ErrQoSNotSupported            = errors.New("QoS not supported by broker")
+	ErrRetainNotSupported         = errors.New("retained messages not supported by broker")
+	ErrPacketTooLarge             = errors.New("packet exceeds the broker's maximum packet size")
+	ErrSharedNotSupported         = errors.New("shared subscriptions not supported by broker")
+	ErrSubscriptionIDNotSupported = errors.New("subscription identifiers not supported by broker")
 )
 
+// AbandonedError is returned by DisconnectTimeout when QoS 1/2 publishes were
+// still waiting for their acknowledgement when the quiesce period ran out.
+type AbandonedError struct {
+	Messages []*packets.PublishPacket
+}
+
+func (e *AbandonedError) Error() string {
+	return fmt.Sprintf("disconnected with %d unacknowledged messages", len(e.Messages))
+}
+
+// ReasonError is returned when the broker refuses a request, carrying the
+// return code (MQTT 3.1.1) or reason code (MQTT 5) it answered with.
+type ReasonError struct {
+	Op      string // what was refused: "connection", "subscription", "publish", ...
+	Code    byte
+	Version byte   // protocol level the code belongs to
+	Reason  string // MQTT 5 Reason String, if the broker sent one
+}
+
+func (e *ReasonError) Error() string {
+	msg := fmt.Sprintf("%s rejected by broker: ", e.Op)
+	switch {
+	case e.Op == "connection" && (e.Version != packets.V5 || e.Code < 0x80):
+		// brokers that do not know MQTT 5 refuse it with a 3.1.1 return code
+		msg += packets.ReturnCodeName(e.Code)
+	case e.Version == packets.V5:
+		msg += packets.ReasonName(e.Code)
+	default:
+		msg += fmt.Sprintf("return code 0x%02X", e.Code)
+	}
+	if e.Reason != "" {
+		msg += " (" + e.Reason + ")"
+	}
+	return msg
+}
+
+// unacceptableVersion reports whether the broker refused the connection
+// because it does not speak the protocol version we asked for
+func (e *ReasonError) unacceptableVersion() bool {
+	if e.Op != "connection" {
+		return false
+	}
+	// brokers that do not know MQTT 5 answer with the 3.1.1 return code
+	return e.Code == packets.UnacceptableProtocolVersion || e.Version == packets.V5 && e.Code == packets.ReasonUnsupportedProtocolVersion
+}
+
+// newReasonError builds a ReasonError from a code and the properties it came with
+func (c *Client) newReasonError(op string, code byte, props *packets.Properties) *ReasonError {
+	err := &ReasonError{Op: op, Code: code, Version: c.ProtocolVersion()}
+	if props != nil {
+		err.Reason = props.ReasonString
+	}
+	return err
+}
+
 type Transport interface {
 	Read([]byte) (n int, err error)
 	Write([]byte) (n int, err error)
@@ -20,123 +89,553 @@ type Transport interface {
 	SetReadDeadline(time.Time) error
 }
 
+// Client is safe for concurrent use by multiple goroutines.
 type Client struct {
-	transport      Transport
-	conn           net.Conn
-	broker         string
-	clientID       string
-	connected      bool
-	messageHandler MessageHandler
-	done           chan bool
-	incoming       chan *packets.PublishPacket
-	useWebsocket   bool
+	transport    Transport
+	conn         net.Conn
+	reader       *bufio.Reader
+	broker       string
+	clientID     string
+	state        atomic.Int32 // one of the state constants below
+	handler      Handler
+	done         chan struct{}                 // closed when the current connection ends
+	readDone     chan struct{}                 // closed when readLoop has returned
+	queues       []chan *packets.PublishPacket // incoming messages, one queue per handler worker
+	useWebsocket bool
+	wg           sync.WaitGroup // tracks readLoop, the handler workers and keepAlive
+	logger       *slog.Logger
+	traceHook    TraceHook
+	version      atomic.Int32 // protocol level of the current connection, see ProtocolVersion
+	versions     []byte       // protocol levels to try in order, see WithProtocolVersions
+
+	// session settings requested in CONNECT, see the With options
+	cleanSession      bool
+	keepAlivePeriod   time.Duration
+	sessionExpiry     time.Duration
+	receiveMaximum    uint16
+	maximumPacketSize uint32
+	topicAliasMaximum uint16
+	authenticator     Authenticator
+	validateClientIDs bool
+
+	// handling of incoming messages, see the With options
+	queueSize       int                          // per handler worker, see WithIncomingQueue
+	overflow        Overflow                     // what to do when a queue is full
+	workers         int                          // see WithHandlerWorkers
+	handlerMode     HandlerMode                  // see WithHandlerMode
+	ackTiming       AckTiming                    // see WithHandlerMode
+	decodeErrors    func(msg Message, err error) // set by WithDecodeErrorHandler
+	droppedIncoming atomic.Uint64                // messages discarded by an overflow policy, see Stats
+
+	writeMu sync.Mutex    // serialises writes so packets never interleave on the wire
+	aliases *topicAliases // outbound topic aliases, guarded by writeMu; nil when the broker allows none
+
+	inAliases map[uint16]string // inbound topic aliases, only used by readLoop
+
+	responseTopic string // set by WithResponseTopic, see replyTopic
+
+	mu       sync.Mutex // guards the fields below
+	nextID   uint16
+	inflight map[uint16]*pending      // packets we sent that still wait for an ack
+	drained  chan struct{}            // closed once no publish is in flight, see DisconnectTimeout
+	lost     bool                     // readLoop has exited, no more acks will arrive
+	dropped  []*packets.PublishPacket // publishes still in flight when the connection went away
+	limits   Limits                   // negotiated in CONNACK
+	quota    chan struct{}            // one slot per QoS 1/2 publish the broker lets us have in flight
+
+	requests            map[string]chan []byte // pending requests by correlation data
+	nextRequest         uint64
+	responsesSubscribed bool // replyTopic is subscribed on the current connection
+	responders          []responder
+	reauth              chan error          // receives the outcome of a running Reauthenticate
+	assignedID          string              // Assigned Client Identifier of an MQTT 5 broker
+	routes              map[string][]*route // SubscribeChan and SubscribeTyped subscriptions by filter
+	routeFilters        topic.Tree          // the filters of routes
+}
+
+// pending is a packet waiting for its acknowledgement from the broker
+type pending struct {
+	ack     chan []byte
+	publish *packets.PublishPacket // nil for SUBSCRIBE and UNSUBSCRIBE
+	acked   bool                   // the final ack has arrived
 }
 
 type MessageHandler func(topic string, payload []byte)
 
-func NewClient(broker, clientID string) *Client {
-	return &Client{
+// connection states, stored atomically in Client.state
+const (
+	disconnected int32 = iota
+	connecting
+	connected
+	disconnecting // tearing the connection down, Connect must wait until it is over
+)
+
+func NewClient(broker, clientID string, opts ...Option) *Client {
+	c := &Client{
 		broker:   broker,
 		clientID: clientID,
-		done:     make(chan bool),
-		incoming: make(chan *packets.PublishPacket, 100), // buffered channel for incoming messages
+		done:     make(chan struct{}),
+		inflight: make(map[uint16]*pending),
+		requests: make(map[string]chan []byte),
+		logger:   slog.New(slog.DiscardHandler),
+		versions: []byte{packets.V311},
+
+		cleanSession:    true,
+		keepAlivePeriod: 60 * time.Second,
+		queueSize:       100,
+		workers:         1,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.version.Store(int32(c.versions[0]))
+	if c.handlerMode == Ordered {
+		c.workers = 1
+	}
+	c.queues = make([]chan *packets.PublishPacket, c.workers)
+	for i := range c.queues {
+		c.queues[i] = make(chan *packets.PublishPacket, c.queueSize)
+	}
+	if c.traceHook == nil {
+		c.traceHook = envTraceHook()
 	}
+	return c
 }
 
+// SetMessageHandler installs a handler that only needs the topic and payload
+// of incoming messages. It replaces a handler set by SetHandler. With
+// AckManual, messages are acknowledged when handler returns.
 func (c *Client) SetMessageHandler(handler MessageHandler) {
-	c.messageHandler = handler
+	if handler == nil {
+		c.SetHandler(nil)
+		return
+	}
+	c.SetHandler(func(msg Message) {
+		// the handler has no Message to acknowledge with AckManual
+		defer msg.Ack()
+		handler(msg.Topic, msg.Payload)
+	})
 }
 
 func (c *Client) Connect() error {
-	conn, err := net.Dial("tcp", c.broker)
+	if !c.state.CompareAndSwap(disconnected, connecting) {
+		return ErrAlreadyConnected
+	}
+
+	// reap the goroutines of a previous connection that was lost
+	c.wg.Wait()
+
+	if c.reader != nil {
+		c.logger.Info("reconnecting", "broker", c.broker, "client_id", c.clientID)
+	} else {
+		c.logger.Info("connecting", "broker", c.broker, "client_id", c.clientID)
+	}
+	var connack *packets.ConnackPacket
+	var err error
+	for i, version := range c.versions {
+		c.version.Store(int32(version))
+		connack, err = c.handshake()
+		var reason *ReasonError
+		if i+1 < len(c.versions) && errors.As(err, &reason) && reason.unacceptableVersion() {
+			c.logger.Info("protocol version refused, falling back", "version", version, "next", c.versions[i+1])
+			continue
+		}
+		break
+	}
 	if err != nil {
+		c.state.Store(disconnected)
 		return err
 	}
+
+	limits := c.negotiate(connack)
+	c.logger.Debug("session negotiated", "limits", limits)
+	if connack.Properties != nil && connack.Properties.AssignedClientIdentifier != "" {
+		c.logger.Info("broker assigned client ID", "client_id", connack.Properties.AssignedClientIdentifier)
+		c.mu.Lock()
+		c.assignedID = connack.Properties.AssignedClientIdentifier
+		c.mu.Unlock()
+	}
+
+	// topic aliases only live as long as the connection
+	c.writeMu.Lock()
+	c.aliases = nil
+	if limits.TopicAliasMaximum > 0 {
+		c.aliases = newTopicAliases(limits.TopicAliasMaximum)
+	}
+	c.writeMu.Unlock()
+	c.inAliases = make(map[uint16]string)
+
+	c.mu.Lock()
+	c.limits = limits
+	c.quota = make(chan struct{}, limits.ReceiveMaximum)
+	c.lost = false
+	c.dropped = nil
+	c.responsesSubscribed = false
+	c.done = make(chan struct{})
+	c.readDone = make(chan struct{})
+	c.mu.Unlock()
+	c.state.Store(connected)
+	c.logger.Info("connected", "broker", c.broker, "client_id", c.clientID, "version", c.ProtocolVersion())
+
+	c.wg.Add(2 + len(c.queues))
+	go c.readLoop() // start reading incoming packets
+	for _, queue := range c.queues {
+		go c.processMessages(queue) // start processing messages
+	}
+	go c.keepAlive() // start keep alive pings
+
+	// a new session has none of the subscriptions behind our routes and responders
+	if !connack.SessionPresent {
+		c.restoreRoutes()
+		c.restoreResponders()
+	}
+
+	return nil
+}
+
+// handshake dials the broker and exchanges CONNECT and CONNACK, including
+// any enhanced authentication, in protocol version ProtocolVersion(). On failure the
+// connection is closed again.
+func (c *Client) handshake() (*packets.ConnackPacket, error) {
+	// resuming the session of an assigned client ID needs that ID
+	clientID := c.clientID
+	if clientID == "" && !c.cleanSession {
+		c.mu.Lock()
+		clientID = c.assignedID
+		c.mu.Unlock()
+	}
+	if err := c.validateClientID(clientID, c.ProtocolVersion()); err != nil {
+		c.logger.Error("invalid client ID", "error", err)
+		return nil, err
+	}
+
+	conn, err := net.Dial("tcp", c.broker)
+	if err != nil {
+		c.logger.Error("dial failed", "broker", c.broker, "error", err)
+		return nil, err
+	}
+	c.writeMu.Lock()
 	c.conn = conn
+	c.writeMu.Unlock()
+	c.reader = bufio.NewReader(conn)
 
 	// create and send CONNECT
 	connectPacket := &packets.ConnectPacket{
-		ProtocolName:    "MQTT",
-		ProtocolVersion: 4,
-		CleanSession:    true,
-		KeepAlive:       60,
-		ClientID:        c.clientID,
+		ProtocolName:    packets.ProtocolName(c.ProtocolVersion()),
+		ProtocolVersion: c.ProtocolVersion(),
+		CleanSession:    c.cleanSession,
+		KeepAlive:       uint16(c.keepAlivePeriod / time.Second),
+		ClientID:        clientID,
+	}
+	if c.ProtocolVersion() == packets.V5 {
+		connectPacket.Properties = c.connectProperties()
+		if c.authenticator != nil {
+			authData, err := c.authenticator.Start()
+			if err != nil {
+				c.logger.Error("starting authentication failed", "error", err)
+				c.conn.Close()
+				return nil, err
+			}
+			connectPacket.Properties.AuthenticationMethod = c.authenticator.Method()
+			connectPacket.Properties.AuthenticationData = authData
+		}
 	}
 
 	data := packets.EncodeConnect(connectPacket)
-	_, err = c.conn.Write(data)
+	err = c.write(data)
 	if err != nil {
+		c.logger.Error("sending CONNECT failed", "error", err)
 		c.conn.Close()
-		return err
+		return nil, err
 	}
 
-	// read CONNACK
-	resp := make([]byte, 4)
-	_, err = c.conn.Read(resp)
+	// read CONNACK, answering the AUTH challenges of enhanced authentication on the way
+	resp, err := c.readPacket()
+	for err == nil && packets.Type(resp) == packets.AUTH {
+		c.logger.Debug("packet received", "type", "AUTH", "bytes", len(resp))
+		c.trace(Inbound, resp)
+		if _, err = c.handleAuth(resp); err != nil {
+			c.logger.Error("authentication failed", "error", err)
+			c.conn.Close()
+			return nil, err
+		}
+		resp, err = c.readPacket()
+	}
 	if err != nil {
+		c.logger.Error("reading CONNACK failed", "error", err)
 		c.conn.Close()
-		return err
+		return nil, err
 	}
+	c.logger.Debug("packet received", "type", packets.TypeName(packets.Type(resp)), "bytes", len(resp))
+	c.trace(Inbound, resp)
 
 	// verify CONNACK status
-	if resp[0] != 0x20 || resp[3] != 0 {
+	connack, err := packets.DecodeConnack(resp, c.ProtocolVersion())
+	if err != nil {
+		c.logger.Error("decoding CONNACK failed", "error", err)
 		c.conn.Close()
-		return errors.New("connection rejected by broker")
+		return nil, err
+	}
+	if connack.ReturnCode != packets.ConnectionAccepted {
+		err := c.newReasonError("connection", connack.ReturnCode, connack.Properties)
+		c.logger.Error("connection rejected by broker", "error", err)
+		c.conn.Close()
+		return nil, err
+	}
+	if c.ProtocolVersion() == packets.V5 && c.authenticator != nil {
+		var authData []byte
+		if connack.Properties != nil {
+			authData = connack.Properties.AuthenticationData
+		}
+		if err := c.authenticator.Finish(authData); err != nil {
+			c.logger.Error("authentication failed", "error", err)
+			c.write(packets.EncodeDisconnectPacket(&packets.DisconnectPacket{Version: c.ProtocolVersion(), ReasonCode: packets.ReasonUnspecifiedError}))
+			c.conn.Close()
+			return nil, err
+		}
 	}
+	return connack, nil
+}
 
-	c.connected = true
+// Disconnect sends DISCONNECT, closes the connection and waits until every
+// goroutine started by Connect has returned. Messages that were already queued
+// are handed to the message handler before Disconnect returns, so it must not
+// be called from inside a message handler.
+func (c *Client) Disconnect() error {
+	// only the first caller gets to tear the connection down
+	if !c.state.CompareAndSwap(connected, disconnecting) {
+		return ErrNotConnected
+	}
+	c.disconnect()
+	return nil
+}
 
-	go c.readLoop()       // start reading incoming packets
-	go c.processMessage() // start processing messages
-	go c.keepAlive()      // start keep alive pings
+// DisconnectTimeout is a graceful Disconnect. New publishes are refused right
+// away, then it waits up to quiesce for the broker to acknowledge the QoS 1/2
+// publishes already in flight before sending DISCONNECT. Messages that are
+// still unacknowledged at the deadline are reported in an *AbandonedError.
+func (c *Client) DisconnectTimeout(quiesce time.Duration) error {
+	if !c.state.CompareAndSwap(connected, disconnecting) {
+		return ErrNotConnected
+	}
 
+	timer := time.NewTimer(quiesce)
+	defer timer.Stop()
+
+	select {
+	case <-c.inflightDrained():
+	case <-timer.C:
+	case <-c.done: // connection lost while waiting, nothing more will be acknowledged
+	}
+
+	c.disconnect()
+
+	// readLoop has returned, so everything not acknowledged by now has been dropped
+	c.mu.Lock()
+	abandoned := c.dropped
+	c.mu.Unlock()
+
+	if len(abandoned) > 0 {
+		c.logger.Warn("abandoned unacknowledged messages", "count", len(abandoned))
+		return &AbandonedError{Messages: abandoned}
+	}
 	return nil
 }
 
-func (c *Client) Disconnect() error {
-	if !c.connected {
-		return ErrNotConnected
+// inflightDrained returns a channel that is closed once no publish waits for an ack
+func (c *Client) inflightDrained() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.drained == nil {
+		c.drained = make(chan struct{})
 	}
+	c.signalDrained()
+	return c.drained
+}
+
+// signalDrained closes drained if someone is waiting and nothing is in flight; c.mu must be held
+func (c *Client) signalDrained() {
+	if c.drained == nil {
+		return
+	}
+	for _, p := range c.inflight {
+		if p.publish != nil && !p.acked {
+			return
+		}
+	}
+	close(c.drained)
+	c.drained = nil
+}
 
+// disconnect tears down the current connection; state must be disconnecting,
+// so that no Connect starts before the old goroutines are gone
+func (c *Client) disconnect() {
 	// send DISCONNECT packet
-	disconnectPacket := []byte{0xE0, 0x00}
-	c.conn.Write(disconnectPacket)
+	c.write(packets.EncodeDisconnectPacket(&packets.DisconnectPacket{Version: c.ProtocolVersion()}))
 
 	c.conn.Close()
-	c.connected = false
+	c.stop()
+	c.wg.Wait()
+	c.state.Store(disconnected)
+	c.logger.Info("disconnected", "broker", c.broker, "client_id", c.clientID)
+}
+
+// Close releases everything held by the client. Unlike Disconnect it also
+// cleans up after a connection the broker has dropped, and calling it more
+// than once is harmless.
+func (c *Client) Close() error {
+	if err := c.Disconnect(); err != nil && !errors.Is(err, ErrNotConnected) {
+		return err
+	}
+	// keep Connect out while reaping the goroutines of a lost connection
+	if c.state.CompareAndSwap(disconnected, disconnecting) {
+		c.wg.Wait()
+		c.state.Store(disconnected)
+	}
 	return nil
 }
 
-// needs to change the arguments to add QoS, retail, etc...
-func (c *Client) Publish(topic, message string) error {
-	if !c.connected {
+// stop signals the goroutines of the current connection to return
+func (c *Client) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+}
+
+// ProtocolVersion returns the protocol level of the current or last
+// connection, which may be a fallback from the preferred one.
+func (c *Client) ProtocolVersion() byte {
+	return byte(c.version.Load())
+}
+
+// IsConnected reports whether the client currently holds a connection to the broker.
+func (c *Client) IsConnected() bool {
+	return c.state.Load() == connected
+}
+
+// Publish sends message to topic. QoS 0 is used unless an option says
+// otherwise; for QoS 1 and 2 Publish blocks until the broker has acknowledged
+// the message.
+func (c *Client) Publish(topic, message string, opts ...PublishOption) error {
+	if !c.IsConnected() {
 		return ErrNotConnected
 	}
 
 	publishPacket := &packets.PublishPacket{
+		Version: c.ProtocolVersion(),
 		Dup:     false,
 		QoS:     0,
 		Retain:  false,
 		Topic:   topic,
 		Payload: []byte(message),
 	}
+	for _, opt := range opts {
+		opt(publishPacket)
+	}
+	if publishPacket.QoS > 2 {
+		return ErrInvalidQoS
+	}
 
-	data := packets.EncodePublish(publishPacket)
-	_, err := c.conn.Write(data)
+	c.mu.Lock()
+	limits, quota, done := c.limits, c.quota, c.done
+	c.mu.Unlock()
+
+	if publishPacket.QoS == 0 {
+		data := packets.EncodePublish(publishPacket)
+		if err := c.checkPublish(publishPacket, len(data), limits); err != nil {
+			return err
+		}
+		return c.writePublish(publishPacket, limits)
+	}
+
+	// the packet ID is not known yet, but it always takes two bytes
+	if err := c.checkPublish(publishPacket, len(packets.EncodePublish(publishPacket)), limits); err != nil {
+		return err
+	}
+
+	// wait for a free slot so we never exceed the broker's Receive Maximum
+	select {
+	case quota <- struct{}{}:
+		defer func() { <-quota }()
+	case <-done:
+		return ErrNotConnected
+	}
+	// DisconnectTimeout may have started while we waited
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+
+	packetID, ack, err := c.track(publishPacket)
+	if err != nil {
+		return err
+	}
+	defer c.untrack(packetID)
+
+	err = c.writePublish(publishPacket, limits)
+	if err != nil {
+		return err
+	}
+
+	// QoS 1: PUBLISH -> PUBACK
+	// QoS 2: PUBLISH -> PUBREC, PUBREL -> PUBCOMP
+	resp, err := c.awaitAck(ack)
 	if err != nil {
 		return err
 	}
+	if publishPacket.QoS == 1 {
+		if resp.PacketType != packets.PUBACK {
+			return fmt.Errorf("expected PUBACK, got %s", packets.TypeName(resp.PacketType))
+		}
+		return nil
+	}
+
+	if resp.PacketType != packets.PUBREC {
+		return fmt.Errorf("expected PUBREC, got %s", packets.TypeName(resp.PacketType))
+	}
+	err = c.sendAck(packets.PUBREL, packetID)
+	if err != nil {
+		return err
+	}
+	resp, err = c.awaitAck(ack)
+	if err != nil {
+		return err
+	}
+	if resp.PacketType != packets.PUBCOMP {
+		return fmt.Errorf("expected PUBCOMP, got %s", packets.TypeName(resp.PacketType))
+	}
 	return nil
 }
 
-func (c *Client) Subscribe(topic string) error {
-	if !c.connected {
+// awaitAck waits for the next PUBACK, PUBREC or PUBCOMP of a publish and
+// turns an MQTT 5 failure reason code into a ReasonError
+func (c *Client) awaitAck(ack chan []byte) (*packets.AckPacket, error) {
+	data, ok := <-ack
+	if !ok {
+		return nil, ErrNotConnected
+	}
+	resp, err := packets.DecodeAck(data, c.ProtocolVersion())
+	if err != nil {
+		return nil, err
+	}
+	if resp.ReasonCode >= 0x80 {
+		return nil, c.newReasonError("publish", resp.ReasonCode, resp.Properties)
+	}
+	return resp, nil
+}
+
+// Subscribe subscribes to the topic filter with QoS 0 unless an option says
+// otherwise, and waits for the broker to confirm the subscription.
+func (c *Client) Subscribe(topic string, opts ...SubscribeOption) error {
+	if !c.IsConnected() {
 		return ErrNotConnected
 	}
 
-	packetID := uint16(1) // or use a counter/generator for multiple subscriptions
 	subscriberPacket := &packets.SubscribePacket{
-		PacketID: packetID,
+		Version: c.ProtocolVersion(),
 		Topics: []packets.Subscription{
 			{
 				Topic: topic,
@@ -144,108 +643,381 @@ func (c *Client) Subscribe(topic string) error {
 			},
 		},
 	}
-	data := packets.EncodeSubscribe(subscriberPacket)
-	_, err := c.conn.Write(data)
+	for _, opt := range opts {
+		opt(subscriberPacket)
+	}
+	if subscriberPacket.Topics[0].QoS > 2 {
+		return ErrInvalidQoS
+	}
+	if err := c.checkSubscribe(subscriberPacket.Topics[0], subscriberPacket.Properties); err != nil {
+		return err
+	}
+
+	packetID, ack, err := c.track(nil)
 	if err != nil {
 		return err
 	}
+	defer c.untrack(packetID)
 
-	// read SUBACK
-	resp := make([]byte, 1024) // SUBACK should be at least 5 bytes (fixed header + packet ID + return code)
-	n, err := c.conn.Read(resp)
+	subscriberPacket.PacketID = packetID
+	data := packets.EncodeSubscribe(subscriberPacket)
+	err = c.write(data)
 	if err != nil {
 		return err
 	}
 
-	suback, err := packets.DecodeSuback(resp[:n])
+	// wait for readLoop to hand us the SUBACK
+	resp, ok := <-ack
+	if !ok {
+		return ErrNotConnected
+	}
+
+	suback, err := packets.DecodeSuback(resp, c.ProtocolVersion())
 	if err != nil {
 		return err
 	}
 
-	if suback.PacketID != packetID || len(suback.ReturnCodes) == 0 || suback.ReturnCodes[0] != 0 {
+	if len(suback.ReturnCodes) == 0 {
 		return errors.New("subscription rejected by broker")
 	}
+	if suback.ReturnCodes[0] >= 0x80 {
+		return c.newReasonError("subscription", suback.ReturnCodes[0], suback.Properties)
+	}
 
 	return nil
 
 }
 
+// Unsubscribe removes the subscription to the topic filter and waits for the
+// broker to confirm it.
+func (c *Client) Unsubscribe(filter string) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+
+	packetID, ack, err := c.track(nil)
+	if err != nil {
+		return err
+	}
+	defer c.untrack(packetID)
+
+	err = c.write(packets.EncodeUnsubscribe(&packets.UnsubscribePacket{
+		Version:  c.ProtocolVersion(),
+		PacketID: packetID,
+		Topics:   []string{filter},
+	}))
+	if err != nil {
+		return err
+	}
+
+	resp, ok := <-ack
+	if !ok {
+		return ErrNotConnected
+	}
+	unsuback, err := packets.DecodeUnsuback(resp, c.ProtocolVersion())
+	if err != nil {
+		return err
+	}
+	// only MQTT 5 reports a result, "no subscription existed" is fine as well
+	if len(unsuback.ReasonCodes) > 0 && unsuback.ReasonCodes[0] >= 0x80 {
+		return c.newReasonError("unsubscribe", unsuback.ReasonCodes[0], unsuback.Properties)
+	}
+	return nil
+}
+
+// SubscribeShared joins the shared subscription of group to filter, so that
+// each matching message goes to only one of the clients subscribed with the
+// same group and filter. Messages arrive with their normal topic and are
+// routed like subscriptions to filter itself.
+func (c *Client) SubscribeShared(group, filter string, opts ...SubscribeOption) error {
+	if !topic.ValidGroup(group) {
+		return fmt.Errorf("invalid share group %q", group)
+	}
+	return c.Subscribe(topic.SharedFilter(group, filter), opts...)
+}
+
+// write sends one complete packet; writes from different goroutines never interleave
+func (c *Client) write(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeLocked(data)
+}
+
+// writePublish sends a PUBLISH, using a topic alias if the broker allows them.
+// Aliases are handed out under writeMu so that the packet teaching the broker
+// an alias always goes out before the first one relying on it. An alias that
+// would push the packet over the broker's Maximum Packet Size is not used.
+func (c *Client) writePublish(publish *packets.PublishPacket, limits Limits) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	data := packets.EncodePublish(publish)
+	if c.aliases != nil {
+		aliased := packets.EncodePublish(c.aliases.withAlias(publish))
+		if limits.MaximumPacketSize == 0 || len(aliased) <= int(limits.MaximumPacketSize) {
+			c.aliases.alias(publish.Topic)
+			data = aliased
+		}
+	}
+	if limits.MaximumPacketSize > 0 && len(data) > int(limits.MaximumPacketSize) {
+		return ErrPacketTooLarge
+	}
+	return c.writeLocked(data)
+}
+
+// writeLocked does the actual write; c.writeMu must be held
+func (c *Client) writeLocked(data []byte) error {
+	_, err := c.conn.Write(data)
+	if err != nil {
+		c.logger.Debug("packet send failed", "type", packets.TypeName(packets.Type(data)), "error", err)
+		return err
+	}
+	c.logger.Debug("packet sent", "type", packets.TypeName(packets.Type(data)), "bytes", len(data))
+	c.trace(Outbound, data)
+	return nil
+}
+
+// track allocates a free packet ID and registers a channel that receives its
+// acks; publish is nil unless the packet being tracked is a PUBLISH
+func (c *Client) track(publish *packets.PublishPacket) (uint16, chan []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lost {
+		return 0, nil, ErrNotConnected
+	}
+	for {
+		c.nextID++
+		if c.nextID == 0 { // 0 is not a valid packet ID
+			continue
+		}
+		if _, used := c.inflight[c.nextID]; !used {
+			break
+		}
+	}
+	if publish != nil {
+		publish.PacketID = c.nextID
+	}
+	ack := make(chan []byte, 1)
+	c.inflight[c.nextID] = &pending{ack: ack, publish: publish}
+	return c.nextID, ack, nil
+}
+
+func (c *Client) untrack(packetID uint16) {
+	c.mu.Lock()
+	delete(c.inflight, packetID)
+	c.signalDrained()
+	c.mu.Unlock()
+}
+
+// acknowledge hands an ack packet to whoever is waiting on its packet ID
+func (c *Client) acknowledge(data []byte) {
+	if len(data) < 4 {
+		return
+	}
+	packetID := uint16(data[2])<<8 | uint16(data[3])
+
+	// the entry stays registered until the waiter untracks it, because a
+	// QoS 2 publish receives both PUBREC and PUBCOMP on the same packet ID
+	c.mu.Lock()
+	p, ok := c.inflight[packetID]
+	if ok && packets.Type(data) != packets.PUBREC {
+		p.acked = true
+		c.signalDrained()
+	}
+	c.mu.Unlock()
+
+	if ok {
+		select {
+		case p.ack <- data:
+		default: // duplicate ack, the waiter has not consumed the previous one yet
+		}
+	}
+}
+
+// failInflight wakes up everyone still waiting for an ack once the connection is gone
+func (c *Client) failInflight() {
+	c.mu.Lock()
+	c.lost = true
+	for packetID, p := range c.inflight {
+		if p.publish != nil && !p.acked {
+			c.dropped = append(c.dropped, p.publish)
+		}
+		close(p.ack)
+		delete(c.inflight, packetID)
+	}
+	c.signalDrained()
+	c.mu.Unlock()
+}
+
+// readPacket reads the next packet from the broker, rejecting one larger than
+// we announced with WithMaximumPacketSize before it is read into memory
+func (c *Client) readPacket() ([]byte, error) {
+	if c.ProtocolVersion() != packets.V5 {
+		return packets.ReadPacket(c.reader)
+	}
+	return packets.ReadPacketMax(c.reader, int(c.maximumPacketSize))
+}
+
 // function to read incoming packets in a loop
 func (c *Client) readLoop() {
+	defer c.wg.Done()
+	defer close(c.readDone)
+	defer c.stop()
+	defer c.failInflight()
+
+	c.mu.Lock()
+	keepAlive := c.limits.KeepAlive
+	c.mu.Unlock()
+
 	for {
-		c.conn.SetReadDeadline(time.Now().Add(45 * time.Second)) // set read timeout to detect disconnections
+		// set read timeout to detect disconnections, keepAlive pings make sure the broker answers in time
+		if keepAlive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 4))
+		}
 
-		resp := make([]byte, 1024)
-		_, err := c.conn.Read(resp)
+		resp, err := c.readPacket()
+		if errors.Is(err, packets.ErrTooLarge) {
+			c.logger.Error("broker exceeded our maximum packet size", "error", err)
+			c.write(packets.EncodeDisconnectPacket(&packets.DisconnectPacket{Version: c.ProtocolVersion(), ReasonCode: packets.ReasonPacketTooLarge}))
+			c.state.CompareAndSwap(connected, disconnected)
+			c.conn.Close()
+			return
+		}
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				fmt.Println("Read timeout, connection may be lost")
-				c.connected = false
-			} else {
-				fmt.Printf("Read error  %v\n", err)
+				c.logger.Warn("read timeout, connection lost", "broker", c.broker)
+			} else if c.IsConnected() {
+				c.logger.Error("read failed, connection lost", "broker", c.broker, "error", err)
 			}
-			c.done <- true
+			c.state.CompareAndSwap(connected, disconnected)
+			c.conn.Close()
 			return
 		}
 
 		c.conn.SetReadDeadline(time.Time{})
+		c.logger.Debug("packet received", "type", packets.TypeName(packets.Type(resp)), "bytes", len(resp))
+		c.trace(Inbound, resp)
 
-		publish, err := packets.DecodePublish(resp)
-		if err != nil {
-			fmt.Printf("Publish decode error : %v\n", err)
-			continue
+		switch packets.Type(resp) {
+		case packets.PUBLISH:
+			publish, err := packets.DecodePublish(resp, c.ProtocolVersion())
+			if err != nil {
+				c.logger.Error("decoding PUBLISH failed", "error", err)
+				continue
+			}
+			if c.ProtocolVersion() == packets.V5 && !resolveAlias(c.inAliases, c.topicAliasMaximum, publish) {
+				c.logger.Error("broker used an unknown topic alias, disconnecting")
+				c.write(packets.EncodeDisconnectPacket(&packets.DisconnectPacket{Version: c.ProtocolVersion(), ReasonCode: packets.ReasonTopicAliasInvalid}))
+				c.state.CompareAndSwap(connected, disconnected)
+				c.conn.Close()
+				return
+			}
+			if c.dispatchReply(publish) {
+				c.ack(publish)
+				continue
+			}
+			if !c.enqueue(publish) {
+				return
+			}
+		case packets.PUBACK, packets.PUBREC, packets.PUBCOMP, packets.SUBACK, packets.UNSUBACK:
+			c.acknowledge(resp)
+		case packets.PUBREL:
+			// last step of an incoming QoS 2 flow
+			if len(resp) >= 4 {
+				c.sendAck(packets.PUBCOMP, uint16(resp[2])<<8|uint16(resp[3]))
+			}
+		case packets.PINGRESP:
+			// nothing to do, the read deadline has already been refreshed
+		case packets.AUTH:
+			// a re-authentication started by Reauthenticate
+			done, err := c.handleAuth(resp)
+			if err != nil {
+				c.logger.Error("re-authentication failed, disconnecting", "error", err)
+				c.finishReauth(err)
+				c.write(packets.EncodeDisconnectPacket(&packets.DisconnectPacket{Version: c.ProtocolVersion(), ReasonCode: packets.ReasonUnspecifiedError}))
+				c.state.CompareAndSwap(connected, disconnected)
+				c.conn.Close()
+				return
+			}
+			if done {
+				c.logger.Info("re-authenticated", "broker", c.broker)
+				c.finishReauth(nil)
+			}
+		case packets.DISCONNECT:
+			// MQTT 5 brokers announce why they are about to close the connection
+			disconnect, err := packets.DecodeDisconnect(resp, c.ProtocolVersion())
+			if err == nil {
+				reason := c.newReasonError("connection", disconnect.ReasonCode, disconnect.Properties)
+				c.logger.Warn("broker sent DISCONNECT", "reason", reason.Error())
+				c.finishReauth(reason)
+			}
+			c.state.CompareAndSwap(connected, disconnected)
+			c.conn.Close()
+			return
 		}
-		c.incoming <- publish
 	}
 }
 
-func (c *Client) processMessage() {
-	for {
-		select {
-		case publish := <-c.incoming:
-			if c.messageHandler != nil {
-				c.messageHandler(publish.Topic, publish.Payload)
-			} else {
-				fmt.Printf("Received message on topic %s: %s\n", publish.Topic, string(publish.Payload))
-			}
+func (c *Client) deliver(publish *packets.PublishPacket) {
+	c.mu.Lock()
+	handler := c.handler
+	done := c.done
+	c.mu.Unlock()
 
-			if publish.QoS == 1 {
-				c.sendPuback(publish.PacketID)
-			}
+	msg := newMessage(publish)
+	manual := c.ackTiming == AckManual && publish.QoS > 0
+	if manual {
+		msg.acker = &acker{client: c, publish: publish, done: done}
+	}
 
-		case <-c.done:
-			return
-		}
+	handed := false // the message went to the application, which acknowledges it with AckManual
+	switch {
+	case c.dispatchRequest(publish):
+		// a reply or a request, already taken care of
+	case c.dispatchRoutes(msg, done):
+		// taken by SubscribeChan or SubscribeTyped
+		handed = true
+	case handler != nil:
+		handler(msg)
+		handed = true
+	default:
+		c.logger.Warn("no message handler, message dropped", "topic", publish.Topic)
 	}
-}
 
-func (c *Client) sendPuback(packetID uint16) error {
-	packet := []byte{
-		0x40, //PUBACK packet type
-		0x02, // remaining length
-		byte(packetID >> 8),
-		byte(packetID & 0xFF),
+	if c.ackTiming == AckAfterHandler || manual && !handed {
+		c.ack(publish)
 	}
-	_, err := c.conn.Write(packet)
-	return err
+}
+
+// sendAck writes one of the acknowledgement packets (PUBACK, PUBREC, PUBREL, PUBCOMP)
+func (c *Client) sendAck(packetType byte, packetID uint16) error {
+	return c.write(packets.EncodeAck(&packets.AckPacket{Version: c.ProtocolVersion(), PacketType: packetType, PacketID: packetID}))
 }
 
 func (c *Client) keepAlive() {
-	ticker := time.NewTicker(30 * time.Second)
+	defer c.wg.Done()
+
+	c.mu.Lock()
+	keepAlive := c.limits.KeepAlive
+	c.mu.Unlock()
+
+	// keep alive disabled, nothing to do until the connection ends
+	if keepAlive <= 0 {
+		<-c.done
+		return
+	}
+
+	ticker := time.NewTicker(keepAlive / 2)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			if !c.connected {
+			if !c.IsConnected() {
 				return
 			}
-			fmt.Println("Sending PINGREQ...")
-			pingreq := []byte{0xC0, 0x00} // PINGREQ packet
-			_, err := c.conn.Write(pingreq)
+			err := c.write(packets.EncodePingreq())
 			if err != nil {
-				fmt.Printf("Ping error : %v\n", err)
-				c.connected = false
+				c.logger.Error("sending PINGREQ failed", "error", err)
 				return
 			}
 		case <-c.done:
@@ -0,0 +1,423 @@
+package mqttc_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+)
+
+func connectV5(t *testing.T, b *fakeBroker, clientID string, opts ...mqttc.Option) *mqttc.Client {
+	t.Helper()
+	opts = append([]mqttc.Option{mqttc.WithProtocolVersion(packets.V5)}, opts...)
+	client := mqttc.NewClient(b.addr(), clientID, opts...)
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return client
+}
+
+func TestV5PublishSubscribe(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connectV5(t, b, "v5")
+	defer client.Disconnect()
+
+	received := make(chan string, 1)
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		received <- string(payload)
+	})
+	if err := client.Subscribe("v5/#"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	for qos := byte(0); qos <= 2; qos++ {
+		if err := client.Publish("v5/topic", "hello", mqttc.PublishQoS(qos)); err != nil {
+			t.Fatalf("Publish QoS %d: %v", qos, err)
+		}
+		select {
+		case got := <-received:
+			if got != "hello" {
+				t.Errorf("received %q; want hello", got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+}
+
+func TestV5ConnectRefused(t *testing.T) {
+	b := newFakeBroker(t)
+	b.connack = packets.ConnackPacket{ReturnCode: packets.ReasonBanned, Properties: &packets.Properties{ReasonString: "go away"}}
+	client := mqttc.NewClient(b.addr(), "banned", mqttc.WithProtocolVersion(packets.V5))
+
+	err := client.Connect()
+	var reason *mqttc.ReasonError
+	if !errors.As(err, &reason) {
+		t.Fatalf("Connect = %v; want *ReasonError", err)
+	}
+	if reason.Code != packets.ReasonBanned || reason.Reason != "go away" {
+		t.Errorf("ReasonError = %+v", reason)
+	}
+	if want := "connection rejected by broker: banned (go away)"; err.Error() != want {
+		t.Errorf("error = %q; want %q", err, want)
+	}
+}
+
+func TestV5PublishRejected(t *testing.T) {
+	b := newFakeBroker(t)
+	b.pubackReason = packets.ReasonQuotaExceeded
+	client := connectV5(t, b, "quota")
+	defer client.Disconnect()
+
+	err := client.Publish("quota/topic", "payload", mqttc.PublishQoS(1))
+	var reason *mqttc.ReasonError
+	if !errors.As(err, &reason) || reason.Code != packets.ReasonQuotaExceeded {
+		t.Fatalf("Publish = %v; want ReasonError with quota exceeded", err)
+	}
+}
+
+func TestV5DisconnectFromServer(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	client := connectV5(t, b, "kicked")
+
+	b.kick(packets.ReasonServerShuttingDown)
+	for deadline := time.Now().Add(2 * time.Second); client.IsConnected(); {
+		if time.Now().After(deadline) {
+			t.Fatal("client ignored the server DISCONNECT")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	client.Close()
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestV5NegotiatedLimits(t *testing.T) {
+	b := newFakeBroker(t)
+	b.connack.Properties = &packets.Properties{
+		SessionExpiryInterval: ptr(uint32(120)),
+		ReceiveMaximum:        ptr(uint16(2)),
+		MaximumPacketSize:     ptr(uint32(64)),
+		MaximumQoS:            ptr(byte(1)),
+		RetainAvailable:       ptr(byte(0)),
+		ServerKeepAlive:       ptr(uint16(5)),
+
+		SharedSubscriptionAvailable:     ptr(byte(0)),
+		SubscriptionIdentifierAvailable: ptr(byte(0)),
+	}
+	client := connectV5(t, b, "limits",
+		mqttc.WithSessionExpiry(time.Hour),
+		mqttc.WithReceiveMaximum(10),
+		mqttc.WithMaximumPacketSize(4096),
+	)
+	defer client.Disconnect()
+
+	props := b.lastConnect().Properties
+	if props == nil || *props.SessionExpiryInterval != 3600 || *props.ReceiveMaximum != 10 || *props.MaximumPacketSize != 4096 {
+		t.Errorf("CONNECT properties = %+v", props)
+	}
+
+	want := mqttc.Limits{
+		SessionExpiry:     2 * time.Minute,
+		ReceiveMaximum:    2,
+		MaximumPacketSize: 64,
+		MaximumQoS:        1,
+		RetainAvailable:   false,
+		KeepAlive:         5 * time.Second,
+
+		SharedSubscriptionAvailable:     false,
+		SubscriptionIdentifierAvailable: false,
+	}
+	if got := client.Limits(); got != want {
+		t.Errorf("Limits = %+v; want %+v", got, want)
+	}
+
+	if err := client.Publish("limits/topic", "x", mqttc.PublishQoS(2)); !errors.Is(err, mqttc.ErrQoSNotSupported) {
+		t.Errorf("Publish QoS 2 = %v; want ErrQoSNotSupported", err)
+	}
+	if err := client.Publish("limits/topic", "x", mqttc.PublishRetain(true)); !errors.Is(err, mqttc.ErrRetainNotSupported) {
+		t.Errorf("Publish retained = %v; want ErrRetainNotSupported", err)
+	}
+	if err := client.SubscribeShared("group", "limits/#"); !errors.Is(err, mqttc.ErrSharedNotSupported) {
+		t.Errorf("SubscribeShared = %v; want ErrSharedNotSupported", err)
+	}
+	if err := client.Subscribe("limits/#", mqttc.SubscribeIdentifier(1)); !errors.Is(err, mqttc.ErrSubscriptionIDNotSupported) {
+		t.Errorf("Subscribe with identifier = %v; want ErrSubscriptionIDNotSupported", err)
+	}
+	if err := client.Publish("limits/topic", strings.Repeat("x", 64)); !errors.Is(err, mqttc.ErrPacketTooLarge) {
+		t.Errorf("Publish 64 byte payload = %v; want ErrPacketTooLarge", err)
+	}
+	if err := client.Publish("limits/topic", "x", mqttc.PublishQoS(1)); err != nil {
+		t.Errorf("Publish within limits: %v", err)
+	}
+}
+
+func TestV5ReceiveMaximum(t *testing.T) {
+	b := newFakeBroker(t)
+	b.holdAcks = true
+	b.connack.Properties = &packets.Properties{ReceiveMaximum: ptr(uint16(2))}
+	client := connectV5(t, b, "receive-max")
+	defer client.Disconnect()
+
+	const total = 6
+	results := make(chan error, total)
+	for i := 0; i < total; i++ {
+		go func() {
+			results <- client.Publish("flow/topic", "x", mqttc.PublishQoS(1))
+		}()
+	}
+
+	for sent := 0; sent < total; sent += 2 {
+		waitFor(t, func() bool { return b.count(packets.PUBLISH) == sent+2 })
+		// give the client a chance to overshoot before checking it did not
+		time.Sleep(20 * time.Millisecond)
+		if n := b.count(packets.PUBLISH); n != sent+2 {
+			t.Fatalf("broker has %d publishes in flight; want at most 2", n-sent)
+		}
+		b.releaseAcks()
+	}
+	for i := 0; i < total; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("Publish: %v", err)
+		}
+	}
+}
+
+func TestV5OutboundTopicAliases(t *testing.T) {
+	b := newFakeBroker(t)
+	b.connack.Properties = &packets.Properties{TopicAliasMaximum: ptr(uint16(2))}
+	client := connectV5(t, b, "aliases")
+	defer client.Disconnect()
+
+	received := make(chan string, 10)
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		received <- topic
+	})
+	if err := client.Subscribe("sensors/#"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	topics := []string{"sensors/a", "sensors/a", "sensors/b", "sensors/c", "sensors/a"}
+	for _, topic := range topics {
+		if err := client.Publish(topic, "x", mqttc.PublishQoS(1)); err != nil {
+			t.Fatalf("Publish(%q): %v", topic, err)
+		}
+		if got := <-received; got != topic {
+			t.Errorf("routed to %q; want %q", got, topic)
+		}
+	}
+
+	// sensors/c evicts the least recently used sensors/a, which in turn evicts sensors/b
+	want := []struct {
+		topic string
+		alias uint16
+	}{
+		{"sensors/a", 1},
+		{"", 1},
+		{"sensors/b", 2},
+		{"sensors/c", 1},
+		{"sensors/a", 2},
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, w := range want {
+		pub := b.publishes[i]
+		if pub.Topic != w.topic || pub.Properties == nil || pub.Properties.TopicAlias == nil || *pub.Properties.TopicAlias != w.alias {
+			t.Errorf("publish %d = topic %q, properties %+v; want topic %q with alias %d", i, pub.Topic, pub.Properties, w.topic, w.alias)
+		}
+	}
+}
+
+func TestV5InboundTopicAliases(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connectV5(t, b, "inbound", mqttc.WithTopicAliasMaximum(1))
+	defer client.Disconnect()
+
+	received := make(chan string, 10)
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		received <- topic + " " + string(payload)
+	})
+	if err := client.Subscribe("long/#"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// the broker only has one alias, so long/two goes out with its full topic
+	for i, topic := range []string{"long/one", "long/one", "long/two", "long/one"} {
+		if err := client.Publish(topic, strconv.Itoa(i)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		select {
+		case got := <-received:
+			if want := topic + " " + strconv.Itoa(i); got != want {
+				t.Errorf("handler got %q; want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+}
+
+func TestV5SubscriptionOptions(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connectV5(t, b, "options")
+	defer client.Disconnect()
+	other := connectV5(t, b, "other")
+	defer other.Disconnect()
+
+	received := make(chan mqttc.Message, 10)
+	client.SetHandler(func(msg mqttc.Message) {
+		received <- msg
+	})
+	err := client.Subscribe("chat/#",
+		mqttc.SubscribeQoS(1),
+		mqttc.SubscribeNoLocal(true),
+		mqttc.SubscribeRetainAsPublished(true),
+		mqttc.SubscribeRetainHandling(packets.RetainDoNotSend),
+		mqttc.SubscribeIdentifier(42),
+	)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	b.mu.Lock()
+	sub := b.subscribes[0]
+	b.mu.Unlock()
+	want := packets.Subscription{Topic: "chat/#", QoS: 1, NoLocal: true, RetainAsPublished: true, RetainHandling: packets.RetainDoNotSend}
+	if sub.Topics[0] != want {
+		t.Errorf("broker got subscription %+v; want %+v", sub.Topics[0], want)
+	}
+
+	// our own message is not echoed back, the other client's is
+	if err := client.Publish("chat/room", "mine"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := other.Publish("chat/room", "theirs"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case msg := <-received:
+		if string(msg.Payload) != "theirs" {
+			t.Errorf("received %q; want theirs", msg.Payload)
+		}
+		if len(msg.SubscriptionIdentifiers) != 1 || msg.SubscriptionIdentifiers[0] != 42 {
+			t.Errorf("subscription identifiers = %v; want [42]", msg.SubscriptionIdentifiers)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	if err := client.Subscribe("chat/#", mqttc.SubscribeQoS(3)); !errors.Is(err, mqttc.ErrInvalidQoS) {
+		t.Errorf("Subscribe QoS 3 = %v; want ErrInvalidQoS", err)
+	}
+	for _, id := range []int{0, -1, 268435456} {
+		if err := client.Subscribe("chat/#", mqttc.SubscribeIdentifier(id)); err == nil {
+			t.Errorf("Subscribe with identifier %d succeeded", id)
+		}
+	}
+	if err := client.Subscribe("chat/#", mqttc.SubscribeRetainHandling(3)); err == nil {
+		t.Error("Subscribe with retain handling 3 succeeded")
+	}
+	if n := b.count(packets.SUBSCRIBE); n != 1 {
+		t.Errorf("broker received %d SUBSCRIBE packets; want only the valid one", n)
+	}
+}
+
+func TestV5IncomingPacketTooLarge(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connectV5(t, b, "smallpackets", mqttc.WithMaximumPacketSize(64))
+	defer client.Close()
+
+	if err := client.Subscribe("big"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := client.Publish("big", strings.Repeat("x", 100)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitFor(t, func() bool { return !client.IsConnected() })
+	waitFor(t, func() bool { return b.count(packets.DISCONNECT) == 1 })
+}
+
+func TestV5TopicAliasRespectsMaximumPacketSize(t *testing.T) {
+	full := packets.EncodePublish(&packets.PublishPacket{Version: packets.V5, QoS: 1, PacketID: 1, Topic: "sensors/a", Payload: []byte("0123456789")})
+	b := newFakeBroker(t)
+	b.connack.Properties = &packets.Properties{
+		TopicAliasMaximum: ptr(uint16(1)),
+		MaximumPacketSize: ptr(uint32(len(full))),
+	}
+	client := connectV5(t, b, "aliaslimit")
+	defer client.Disconnect()
+
+	// with a new alias the packet would be 3 bytes over, so it goes out without one
+	if err := client.Publish("sensors/a", "0123456789", mqttc.PublishQoS(1)); err != nil {
+		t.Fatalf("Publish at the size limit: %v", err)
+	}
+	// a smaller publish to the same topic sets the alias up as usual
+	if err := client.Publish("sensors/a", "x", mqttc.PublishQoS(1)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := client.Publish("sensors/a", "0123456789", mqttc.PublishQoS(1)); err != nil {
+		t.Fatalf("Publish with a known alias: %v", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	want := []struct {
+		topic string
+		alias uint16 // 0 for none
+	}{
+		{"sensors/a", 0},
+		{"sensors/a", 1},
+		{"", 1},
+	}
+	for i, w := range want {
+		pub := b.publishes[i]
+		var alias uint16
+		if pub.Properties != nil && pub.Properties.TopicAlias != nil {
+			alias = *pub.Properties.TopicAlias
+		}
+		if pub.Topic != w.topic || alias != w.alias {
+			t.Errorf("publish %d = topic %q, alias %d; want topic %q, alias %d", i, pub.Topic, alias, w.topic, w.alias)
+		}
+	}
+}
+
+func TestV5DisconnectTimeoutRefusesQueuedPublish(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	b.holdAcks = true
+	b.connack.Properties = &packets.Properties{ReceiveMaximum: ptr(uint16(1))}
+	client := connectV5(t, b, "quiesce")
+
+	first := make(chan error, 1)
+	go func() { first <- client.Publish("quiesce/a", "x", mqttc.PublishQoS(1)) }()
+	waitFor(t, func() bool { return b.count(packets.PUBLISH) == 1 })
+
+	// this one waits for the only Receive Maximum slot
+	second := make(chan error, 1)
+	go func() { second <- client.Publish("quiesce/b", "x", mqttc.PublishQoS(1)) }()
+	time.Sleep(20 * time.Millisecond)
+
+	disconnected := make(chan error, 1)
+	go func() { disconnected <- client.DisconnectTimeout(2 * time.Second) }()
+	waitFor(t, func() bool { return !client.IsConnected() })
+	b.releaseAcks()
+
+	if err := <-disconnected; err != nil {
+		t.Fatalf("DisconnectTimeout: %v", err)
+	}
+	if err := <-first; err != nil {
+		t.Errorf("in-flight Publish: %v", err)
+	}
+	if err := <-second; !errors.Is(err, mqttc.ErrNotConnected) {
+		t.Errorf("Publish waiting for a slot = %v; want ErrNotConnected", err)
+	}
+	if n := b.count(packets.PUBLISH); n != 1 {
+		t.Errorf("broker received %d PUBLISH packets; want 1", n)
+	}
+}
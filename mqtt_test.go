@@ -0,0 +1,347 @@
+package mqttc_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+	"github.com/gorunriki/mqttc/topic"
+)
+
+func connect(t *testing.T, b *fakeBroker, clientID string) *mqttc.Client {
+	t.Helper()
+	client := mqttc.NewClient(b.addr(), clientID)
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	return client
+}
+
+func TestConnectTwice(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "twice")
+	defer client.Disconnect()
+
+	if err := client.Connect(); !errors.Is(err, mqttc.ErrAlreadyConnected) {
+		t.Fatalf("second Connect = %v; want ErrAlreadyConnected", err)
+	}
+}
+
+func TestConcurrentPublishSubscribe(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "concurrent")
+	defer client.Disconnect()
+
+	const workers = 8
+	const perWorker = 50
+
+	var mu sync.Mutex
+	got := make(map[string]int)
+	total := 0
+	done := make(chan struct{})
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		got[topic]++
+		total++
+		if total == workers*perWorker {
+			close(done)
+		}
+	})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			topic := fmt.Sprintf("load/%d", w)
+			if err := client.Subscribe(topic); err != nil {
+				t.Errorf("Subscribe(%q): %v", topic, err)
+				return
+			}
+			for i := 0; i < perWorker; i++ {
+				if err := client.Publish(topic, fmt.Sprintf("msg %d", i)); err != nil {
+					t.Errorf("Publish(%q): %v", topic, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		mu.Lock()
+		defer mu.Unlock()
+		t.Fatalf("timed out waiting for messages, got %v", got)
+	}
+
+	if n := b.count(packets.PUBLISH); n != workers*perWorker {
+		t.Errorf("broker received %d PUBLISH packets; want %d", n, workers*perWorker)
+	}
+}
+
+func TestConcurrentDisconnect(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	client := connect(t, b, "racer")
+
+	var wg sync.WaitGroup
+	var disconnects sync.Map
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			client.Publish("race/topic", "payload")
+		}()
+		go func() {
+			defer wg.Done()
+			client.Subscribe("race/#")
+		}()
+		go func() {
+			defer wg.Done()
+			disconnects.Store(i, client.Disconnect())
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	disconnects.Range(func(_, v any) bool {
+		if v == nil {
+			succeeded++
+		} else if !errors.Is(v.(error), mqttc.ErrNotConnected) {
+			t.Errorf("Disconnect = %v; want nil or ErrNotConnected", v)
+		}
+		return true
+	})
+	if succeeded != 1 {
+		t.Errorf("%d Disconnect calls succeeded; want exactly 1", succeeded)
+	}
+	if client.IsConnected() {
+		t.Error("client still reports connected after Disconnect")
+	}
+	if err := client.Publish("race/topic", "late"); !errors.Is(err, mqttc.ErrNotConnected) {
+		t.Errorf("Publish after Disconnect = %v; want ErrNotConnected", err)
+	}
+}
+
+func TestConcurrentConnectDisconnect(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "flapper")
+
+	// Connect racing a Disconnect must either fail or wait for the teardown
+	for range 20 {
+		client.Connect()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			client.Disconnect()
+		}()
+		go func() {
+			defer wg.Done()
+			if err := client.Connect(); err != nil && !errors.Is(err, mqttc.ErrAlreadyConnected) {
+				t.Errorf("Connect = %v; want nil or ErrAlreadyConnected", err)
+			}
+		}()
+		wg.Wait()
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestDisconnectStopsGoroutines(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "leak")
+
+	if err := client.Subscribe("leak/#"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+
+	// Disconnect waits for the goroutines, so there must be none left right away
+	if leaked := clientGoroutines(); len(leaked) != 0 {
+		t.Fatalf("%d client goroutines survived Disconnect:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+	}
+}
+
+func TestCloseAfterConnectionLost(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	client := connect(t, b, "lost")
+
+	b.close()
+	for deadline := time.Now().Add(2 * time.Second); client.IsConnected(); {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not notice the broker went away")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := client.Disconnect(); !errors.Is(err, mqttc.ErrNotConnected) {
+		t.Errorf("Disconnect after loss = %v; want ErrNotConnected", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestReconnectAfterDisconnect(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	client := connect(t, b, "again")
+
+	for i := 0; i < 3; i++ {
+		if err := client.Disconnect(); err != nil {
+			t.Fatalf("Disconnect #%d: %v", i, err)
+		}
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect #%d: %v", i, err)
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestPublishQoS(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "qos")
+	defer client.Disconnect()
+
+	for qos := byte(0); qos <= 2; qos++ {
+		if err := client.Publish("qos/topic", "payload", mqttc.PublishQoS(qos)); err != nil {
+			t.Errorf("Publish QoS %d: %v", qos, err)
+		}
+	}
+	if err := client.Publish("qos/topic", "payload", mqttc.PublishQoS(3)); !errors.Is(err, mqttc.ErrInvalidQoS) {
+		t.Errorf("Publish QoS 3 = %v; want ErrInvalidQoS", err)
+	}
+	if n := b.count(packets.PUBREL); n != 1 {
+		t.Errorf("broker received %d PUBREL packets; want 1", n)
+	}
+}
+
+// publishAsync starts a QoS 1 publish and waits until the broker has received it
+func publishAsync(t *testing.T, b *fakeBroker, client *mqttc.Client, topic string) <-chan error {
+	t.Helper()
+	before := b.count(packets.PUBLISH)
+	result := make(chan error, 1)
+	go func() {
+		result <- client.Publish(topic, "payload", mqttc.PublishQoS(1))
+	}()
+	for deadline := time.Now().Add(2 * time.Second); b.count(packets.PUBLISH) == before; {
+		if time.Now().After(deadline) {
+			t.Fatal("broker never received the publish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return result
+}
+
+func TestDisconnectTimeoutWaitsForAcks(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	b.ackDelay = 200 * time.Millisecond
+	client := connect(t, b, "graceful")
+
+	result := publishAsync(t, b, client, "graceful/topic")
+	if err := client.DisconnectTimeout(2 * time.Second); err != nil {
+		t.Fatalf("DisconnectTimeout: %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Publish: %v", err)
+	}
+	if err := client.Publish("graceful/topic", "late"); !errors.Is(err, mqttc.ErrNotConnected) {
+		t.Errorf("Publish after DisconnectTimeout = %v; want ErrNotConnected", err)
+	}
+}
+
+func TestDisconnectTimeoutReportsAbandoned(t *testing.T) {
+	verifyNoLeaks(t)
+	b := newFakeBroker(t)
+	b.holdAcks = true
+	client := connect(t, b, "abandon")
+
+	result := publishAsync(t, b, client, "abandon/topic")
+	err := client.DisconnectTimeout(50 * time.Millisecond)
+
+	var abandoned *mqttc.AbandonedError
+	if !errors.As(err, &abandoned) {
+		t.Fatalf("DisconnectTimeout = %v; want *AbandonedError", err)
+	}
+	if len(abandoned.Messages) != 1 || abandoned.Messages[0].Topic != "abandon/topic" {
+		t.Errorf("abandoned = %+v; want the single abandon/topic publish", abandoned.Messages)
+	}
+	if err := <-result; !errors.Is(err, mqttc.ErrNotConnected) {
+		t.Errorf("abandoned Publish = %v; want ErrNotConnected", err)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "logged", mqttc.WithLogger(logger))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if err := client.Publish("log/topic", "payload"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		`msg=connected`,
+		`msg="packet sent" type=PUBLISH`,
+		`msg="packet received" type=CONNACK`,
+		`msg=disconnected`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestInvalidTopics(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "invalid")
+	defer client.Disconnect()
+
+	for _, name := range []string{"", "sport/+", "sport/#", "sp\x00rt", strings.Repeat("a", 65536)} {
+		if err := client.Publish(name, "x", mqttc.PublishQoS(1)); !errors.Is(err, topic.ErrInvalidName) {
+			t.Errorf("Publish(%.20q) = %v; want ErrInvalidName", name, err)
+		}
+	}
+	for _, filter := range []string{"", "sport/#/x", "sp+rt", "sp\x00rt"} {
+		if err := client.Subscribe(filter); !errors.Is(err, topic.ErrInvalidFilter) {
+			t.Errorf("Subscribe(%q) = %v; want ErrInvalidFilter", filter, err)
+		}
+	}
+
+	// nothing but CONNECT reached the broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.received) != 1 {
+		t.Errorf("broker received %d packets; want only CONNECT", len(b.received))
+	}
+}
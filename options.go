@@ -0,0 +1,280 @@
+package mqttc
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// Option configures a Client, see NewClient.
+type Option func(*Client)
+
+// WithLogger makes the client report connection events, packet traffic and
+// errors to logger. By default nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithProtocolVersion selects the protocol level spoken with the broker,
+// packets.V311 (the default), packets.V5 or packets.V31.
+func WithProtocolVersion(version byte) Option {
+	return WithProtocolVersions(version)
+}
+
+// WithProtocolVersions makes Connect try the given protocol levels in order
+// of preference, falling back to the next one whenever the broker answers
+// that it does not accept the version, e.g. packets.V5, packets.V311, packets.V31.
+func WithProtocolVersions(versions ...byte) Option {
+	return func(c *Client) {
+		if len(versions) > 0 {
+			c.versions = versions
+		}
+	}
+}
+
+// WithCleanSession sets the Clean Session (MQTT 5: Clean Start) flag, true by default.
+// Set it to false to resume the session the broker kept for this client ID.
+func WithCleanSession(clean bool) Option {
+	return func(c *Client) {
+		c.cleanSession = clean
+	}
+}
+
+// WithKeepAlive sets the keep alive interval sent in CONNECT, 60 seconds by
+// default. An MQTT 5 broker may override it with Server Keep Alive.
+func WithKeepAlive(keepAlive time.Duration) Option {
+	return func(c *Client) {
+		c.keepAlivePeriod = keepAlive
+	}
+}
+
+// WithSessionExpiry asks an MQTT 5 broker to keep the session for expiry
+// after the connection closes. The broker may choose a different value,
+// see Client.Limits.
+func WithSessionExpiry(expiry time.Duration) Option {
+	return func(c *Client) {
+		c.sessionExpiry = expiry
+	}
+}
+
+// WithReceiveMaximum tells an MQTT 5 broker how many QoS 1/2 messages it may
+// send us before we acknowledge them.
+func WithReceiveMaximum(max uint16) Option {
+	return func(c *Client) {
+		c.receiveMaximum = max
+	}
+}
+
+// WithMaximumPacketSize tells an MQTT 5 broker the largest packet we accept.
+// Larger packets that arrive anyway close the connection.
+func WithMaximumPacketSize(max uint32) Option {
+	return func(c *Client) {
+		c.maximumPacketSize = max
+	}
+}
+
+// WithTopicAliasMaximum lets an MQTT 5 broker replace topics of the messages
+// it sends us by up to max topic aliases. They are resolved before messages
+// reach the handler. Aliases for our own publishes are used automatically
+// whenever the broker allows them.
+func WithTopicAliasMaximum(max uint16) Option {
+	return func(c *Client) {
+		c.topicAliasMaximum = max
+	}
+}
+
+// WithAuthenticator enables MQTT 5 enhanced authentication: authenticator
+// runs its exchange with the broker during Connect and Reauthenticate.
+// NewSCRAMSHA256 provides SCRAM-SHA-256.
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(c *Client) {
+		c.authenticator = authenticator
+	}
+}
+
+// WithClientIDValidation turns the client ID check of Connect on or off. It
+// is off by default; when on, it only lets IDs through that every broker must
+// accept: 1 to 23 characters from 0-9, a-z and A-Z. Many brokers accept more.
+func WithClientIDValidation(enabled bool) Option {
+	return func(c *Client) {
+		c.validateClientIDs = enabled
+	}
+}
+
+// WithRandomClientID replaces the client ID passed to NewClient by prefix
+// followed by random characters, filling up the 23 characters every broker
+// accepts. Keep prefix alphanumeric and short; at least 8 random characters
+// are always added.
+func WithRandomClientID(prefix string) Option {
+	return func(c *Client) {
+		c.clientID = randomClientID(prefix)
+	}
+}
+
+// WithResponseTopic sets the topic that replies to Request are sent to,
+// "mqttc/responses/" followed by the client ID by default.
+func WithResponseTopic(topic string) Option {
+	return func(c *Client) {
+		c.responseTopic = topic
+	}
+}
+
+// WithDecodeErrorHandler installs the function told about messages that a
+// SubscribeTyped subscription could not decode. Such messages are skipped
+// and, by default, only logged.
+func WithDecodeErrorHandler(handler func(msg Message, err error)) Option {
+	return func(c *Client) {
+		c.decodeErrors = handler
+	}
+}
+
+// WithIncomingQueue sets how many received messages may wait for the
+// handler, 100 by default, and what happens when that many are waiting. With
+// Block, the default, the client stops reading from the connection until the
+// handler catches up, so a handler slower than the keep alive interval loses
+// the connection. DropOldest and DropNewest keep reading and discard messages
+// instead, see Stats. With several handler workers, each has a queue of size.
+func WithIncomingQueue(size int, overflow Overflow) Option {
+	return func(c *Client) {
+		if size > 0 {
+			c.queueSize = size
+		}
+		c.overflow = overflow
+	}
+}
+
+// WithHandlerWorkers runs the message handler on n goroutines at once. Each
+// topic is always handled by the same worker, so messages on one topic still
+// arrive in order, while a slow message does not hold up other topics that
+// happen to go to another worker. The handler must be safe for concurrent use.
+// The Ordered handler mode ignores n.
+func WithHandlerWorkers(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.workers = n
+		}
+	}
+}
+
+// WithHandlerMode sets whether messages are handled in order per topic or
+// strictly one after the other, and when QoS 1 and 2 messages are
+// acknowledged. The default is PerTopic with AckAfterHandler.
+func WithHandlerMode(mode HandlerMode, ack AckTiming) Option {
+	return func(c *Client) {
+		c.handlerMode = mode
+		c.ackTiming = ack
+	}
+}
+
+// PublishOption changes how a single message is published.
+type PublishOption func(*packets.PublishPacket)
+
+// PublishQoS sets the quality of service level (0, 1 or 2) of the message.
+func PublishQoS(qos byte) PublishOption {
+	return func(p *packets.PublishPacket) {
+		p.QoS = qos
+	}
+}
+
+// PublishRetain asks the broker to keep the message as the retained message of its topic.
+func PublishRetain(retain bool) PublishOption {
+	return func(p *packets.PublishPacket) {
+		p.Retain = retain
+	}
+}
+
+// PublishUserProperty adds a user property to an MQTT 5 message. It may be
+// given more than once, also with the same key.
+func PublishUserProperty(key, value string) PublishOption {
+	return func(p *packets.PublishPacket) {
+		props := publishProperties(p)
+		props.UserProperties = append(props.UserProperties, packets.UserProperty{Key: key, Value: value})
+	}
+}
+
+// PublishContentType sets the MQTT 5 Content Type of the message, usually a MIME type.
+func PublishContentType(contentType string) PublishOption {
+	return func(p *packets.PublishPacket) {
+		publishProperties(p).ContentType = contentType
+	}
+}
+
+// PublishPayloadFormat sets the MQTT 5 Payload Format Indicator, 1 for UTF-8
+// text and 0 for unspecified bytes.
+func PublishPayloadFormat(format byte) PublishOption {
+	return func(p *packets.PublishPacket) {
+		publishProperties(p).PayloadFormatIndicator = &format
+	}
+}
+
+// PublishMessageExpiry tells an MQTT 5 broker to discard the message if it
+// could not be delivered within expiry. It is rounded down to whole seconds.
+func PublishMessageExpiry(expiry time.Duration) PublishOption {
+	return func(p *packets.PublishPacket) {
+		seconds := uint32(expiry / time.Second)
+		publishProperties(p).MessageExpiryInterval = &seconds
+	}
+}
+
+// publishProperties returns the properties of p, creating them if necessary
+func publishProperties(p *packets.PublishPacket) *packets.Properties {
+	if p.Properties == nil {
+		p.Properties = &packets.Properties{}
+	}
+	return p.Properties
+}
+
+// SubscribeOption changes how a single subscription is made. The options
+// other than SubscribeQoS only take effect with MQTT 5.
+type SubscribeOption func(*packets.SubscribePacket)
+
+// SubscribeQoS sets the maximum quality of service level (0, 1 or 2) the
+// broker uses for messages sent to this subscription.
+func SubscribeQoS(qos byte) SubscribeOption {
+	return func(p *packets.SubscribePacket) {
+		p.Topics[0].QoS = qos
+	}
+}
+
+// SubscribeNoLocal stops the broker from sending our own publishes back to
+// this subscription.
+func SubscribeNoLocal(noLocal bool) SubscribeOption {
+	return func(p *packets.SubscribePacket) {
+		p.Topics[0].NoLocal = noLocal
+	}
+}
+
+// SubscribeRetainAsPublished keeps the retain flag messages were published
+// with instead of clearing it on forwarded messages.
+func SubscribeRetainAsPublished(retainAsPublished bool) SubscribeOption {
+	return func(p *packets.SubscribePacket) {
+		p.Topics[0].RetainAsPublished = retainAsPublished
+	}
+}
+
+// SubscribeRetainHandling sets when the broker sends the retained messages
+// matching the filter, one of packets.RetainSendOnSubscribe (the default),
+// packets.RetainSendIfNew and packets.RetainDoNotSend.
+func SubscribeRetainHandling(handling byte) SubscribeOption {
+	return func(p *packets.SubscribePacket) {
+		p.Topics[0].RetainHandling = handling
+	}
+}
+
+// SubscribeIdentifier tags the subscription with id (1 to 268435455). The
+// broker reports it in Message.SubscriptionIdentifiers of matching messages.
+// Subscribe fails with ErrSubscriptionIDNotSupported if the broker announced
+// that it does not support subscription identifiers.
+func SubscribeIdentifier(id int) SubscribeOption {
+	return func(p *packets.SubscribePacket) {
+		if p.Properties == nil {
+			p.Properties = &packets.Properties{}
+		}
+		p.Properties.SubscriptionIdentifiers = []int{id}
+	}
+}
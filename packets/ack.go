@@ -0,0 +1,58 @@
+package packets
+
+import "fmt"
+
+// AckPacket is one of the packets that acknowledge a publish by packet
+// identifier: PUBACK, PUBREC, PUBREL and PUBCOMP.
+type AckPacket struct {
+	Version    byte // protocol level, V5 adds reason code and properties
+	PacketType byte
+	PacketID   uint16
+	ReasonCode byte        // MQTT 5 only
+	Properties *Properties // MQTT 5 only
+}
+
+func EncodeAck(packet *AckPacket) []byte {
+	header := packet.PacketType << 4
+	if packet.PacketType == PUBREL {
+		header |= 0x02 // PUBREL has the reserved flag bit 1 set
+	}
+	variableHeader := []byte{byte(packet.PacketID >> 8), byte(packet.PacketID & 0xFF)}
+
+	// MQTT 5 lets us leave out a success reason code without properties
+	if packet.Version == V5 && (packet.ReasonCode != ReasonSuccess || packet.Properties != nil) {
+		variableHeader = append(variableHeader, packet.ReasonCode)
+		if packet.Properties != nil {
+			variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+		}
+	}
+	return withFixedHeader(header, variableHeader)
+}
+
+// DecodeAck parses a full PUBACK, PUBREC, PUBREL or PUBCOMP packet (starting from fixed header)
+func DecodeAck(data []byte, version byte) (*AckPacket, error) {
+	buf, err := body(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("missing packet identifier")
+	}
+	packet := &AckPacket{
+		Version:    version,
+		PacketType: Type(data),
+		PacketID:   uint16(buf[0])<<8 | uint16(buf[1]),
+	}
+	if version == V5 {
+		if len(buf) > 2 {
+			packet.ReasonCode = buf[2]
+		}
+		if len(buf) > 3 {
+			packet.Properties, _, err = decodeProperties(buf, 3)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return packet, nil
+}
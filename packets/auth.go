@@ -0,0 +1,42 @@
+package packets
+
+import "fmt"
+
+// AuthPacket carries an MQTT 5 enhanced authentication exchange. The
+// Authentication Method and Data travel in its properties.
+type AuthPacket struct {
+	ReasonCode byte // ReasonSuccess, ReasonContinueAuthentication or ReasonReAuthenticate
+	Properties *Properties
+}
+
+func EncodeAuth(packet *AuthPacket) []byte {
+	// a successful AUTH without properties may be sent as an empty packet
+	if packet.ReasonCode == ReasonSuccess && packet.Properties == nil {
+		return []byte{0xF0, 0x00}
+	}
+	variableHeader := []byte{packet.ReasonCode}
+	variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+	return withFixedHeader(0xF0, variableHeader)
+}
+
+// DecodeAuth parses a full AUTH packet (starting from fixed header)
+func DecodeAuth(data []byte) (*AuthPacket, error) {
+	buf, err := body(data)
+	if err != nil {
+		return nil, err
+	}
+	if Type(data) != AUTH {
+		return nil, fmt.Errorf("not an AUTH packet")
+	}
+	packet := &AuthPacket{}
+	if len(buf) > 0 {
+		packet.ReasonCode = buf[0]
+	}
+	if len(buf) > 1 {
+		packet.Properties, _, err = decodeProperties(buf, 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return packet, nil
+}
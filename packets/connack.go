@@ -0,0 +1,73 @@
+package packets
+
+import "fmt"
+
+// CONNACK return codes (MQTT 3.1.1, MQTT 5 uses the Reason codes)
+const (
+	ConnectionAccepted          byte = 0x00
+	UnacceptableProtocolVersion byte = 0x01
+	IdentifierRejected          byte = 0x02
+	ServerUnavailable           byte = 0x03
+	BadUsernameOrPassword       byte = 0x04
+	NotAuthorized               byte = 0x05
+)
+
+type ConnackPacket struct {
+	Version        byte // protocol level, V5 adds properties
+	SessionPresent bool
+	ReturnCode     byte        // reason code in MQTT 5
+	Properties     *Properties // MQTT 5 only
+}
+
+func EncodeConnack(packet *ConnackPacket) []byte {
+	flags := byte(0)
+	if packet.SessionPresent {
+		flags |= 0x01
+	}
+	variableHeader := []byte{flags, packet.ReturnCode}
+	if packet.Version == V5 {
+		variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+	}
+	return withFixedHeader(0x20, variableHeader)
+}
+
+// DecodeConnack parses a full CONNACK packet (starting from fixed header)
+func DecodeConnack(data []byte, version byte) (*ConnackPacket, error) {
+	buf, err := body(data)
+	if err != nil {
+		return nil, err
+	}
+	if Type(data) != CONNACK || len(buf) < 2 {
+		return nil, fmt.Errorf("malformed CONNACK")
+	}
+	packet := &ConnackPacket{
+		Version:        version,
+		SessionPresent: buf[0]&0x01 != 0,
+		ReturnCode:     buf[1],
+	}
+	// a broker refusing our protocol level may answer in 3.1.1 format without properties
+	if version == V5 && len(buf) > 2 {
+		packet.Properties, _, err = decodeProperties(buf, 2)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return packet, nil
+}
+
+var returnCodeNames = map[byte]string{
+	ConnectionAccepted:          "connection accepted",
+	UnacceptableProtocolVersion: "unacceptable protocol version",
+	IdentifierRejected:          "identifier rejected",
+	ServerUnavailable:           "server unavailable",
+	BadUsernameOrPassword:       "bad user name or password",
+	NotAuthorized:               "not authorized",
+}
+
+// ReturnCodeName returns the description of an MQTT 3.1.1 CONNACK return code.
+func ReturnCodeName(code byte) string {
+	if name, ok := returnCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("return code 0x%02X", code)
+}
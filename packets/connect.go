@@ -1,11 +1,37 @@
 package packets
 
+import "fmt"
+
 type ConnectPacket struct {
 	ProtocolName    string
 	ProtocolVersion byte
-	CleanSession    bool
+	CleanSession    bool // Clean Start in MQTT 5
 	KeepAlive       uint16
 	ClientID        string
+
+	// optional will message, sent by the broker if the client disappears
+	WillFlag       bool
+	WillQoS        byte
+	WillRetain     bool
+	WillTopic      string
+	WillMessage    []byte
+	WillProperties *Properties // MQTT 5 only
+
+	UsernameFlag bool
+	Username     string
+	PasswordFlag bool
+	Password     []byte
+
+	Properties *Properties // MQTT 5 only
+}
+
+// ProtocolName returns the protocol name sent in CONNECT for a protocol level:
+// "MQIsdp" for MQTT 3.1 and "MQTT" otherwise.
+func ProtocolName(version byte) string {
+	if version == V31 {
+		return "MQIsdp"
+	}
+	return "MQTT"
 }
 
 func EncodeConnect(packet *ConnectPacket) []byte {
@@ -14,18 +40,38 @@ func EncodeConnect(packet *ConnectPacket) []byte {
 	// init variableHeader
 	variableHeader := []byte{}
 
-	// add protocol name
-	variableHeader = append(variableHeader, 0, 4)
-	variableHeader = append(variableHeader, []byte("MQTT")...)
+	// add protocol version, 3.1.1 unless told otherwise
+	version := packet.ProtocolVersion
+	if version == 0 {
+		version = V311
+	}
 
-	// add protocol version
-	variableHeader = append(variableHeader, 4)
+	// add protocol name, the one belonging to the version unless told otherwise
+	name := packet.ProtocolName
+	if name == "" {
+		name = ProtocolName(version)
+	}
+	variableHeader = appendString(variableHeader, name)
+	variableHeader = append(variableHeader, version)
 
-	// init connect flags, need to change if starts using username, password, etc..
+	// init connect flags
 	connectFlags := byte(0)
 	if packet.CleanSession {
 		connectFlags |= 0x02
 	}
+	if packet.WillFlag {
+		connectFlags |= 0x04
+		connectFlags |= (packet.WillQoS & 0x03) << 3
+		if packet.WillRetain {
+			connectFlags |= 0x20
+		}
+	}
+	if packet.PasswordFlag {
+		connectFlags |= 0x40
+	}
+	if packet.UsernameFlag {
+		connectFlags |= 0x80
+	}
 
 	// add connect flag
 	variableHeader = append(variableHeader, connectFlags)
@@ -33,11 +79,30 @@ func EncodeConnect(packet *ConnectPacket) []byte {
 	// add keep alive (2 bytes, big endian)
 	variableHeader = append(variableHeader, byte(packet.KeepAlive>>8), byte(packet.KeepAlive&0xFF))
 
+	// add properties
+	if version == V5 {
+		variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+	}
+
 	// add pyaload
 	clientID := packet.ClientID
 	variableHeader = append(variableHeader, byte(len(clientID)>>8), byte(len(clientID)&0xFF))
 	variableHeader = append(variableHeader, []byte(clientID)...)
 
+	if packet.WillFlag {
+		if version == V5 {
+			variableHeader = append(variableHeader, encodeProperties(packet.WillProperties)...)
+		}
+		variableHeader = appendString(variableHeader, packet.WillTopic)
+		variableHeader = appendString(variableHeader, string(packet.WillMessage))
+	}
+	if packet.UsernameFlag {
+		variableHeader = appendString(variableHeader, packet.Username)
+	}
+	if packet.PasswordFlag {
+		variableHeader = appendString(variableHeader, string(packet.Password))
+	}
+
 	// add remail length to fixed header
 	remainingLength := len(variableHeader)
 	result = append(result, encodeLength(remainingLength)...)
@@ -65,3 +130,78 @@ func encodeLength(length int) []byte {
 	}
 	return result
 }
+
+// DecodeConnect parses a full CONNECT packet (starting from fixed header).
+// The protocol version is read from the packet itself.
+func DecodeConnect(data []byte) (*ConnectPacket, error) {
+	buf, err := body(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// protocol name
+	name, pos, err := readString(buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("malformed protocol name")
+	}
+
+	// protocol version, connect flags and keep alive
+	if pos+4 > len(buf) {
+		return nil, fmt.Errorf("malformed variable header")
+	}
+	flags := buf[pos+1]
+	packet := &ConnectPacket{
+		ProtocolName:    name,
+		ProtocolVersion: buf[pos],
+		CleanSession:    flags&0x02 != 0,
+		WillFlag:        flags&0x04 != 0,
+		WillQoS:         (flags >> 3) & 0x03,
+		WillRetain:      flags&0x20 != 0,
+		PasswordFlag:    flags&0x40 != 0,
+		UsernameFlag:    flags&0x80 != 0,
+		KeepAlive:       uint16(buf[pos+2])<<8 | uint16(buf[pos+3]),
+	}
+	pos += 4
+
+	if packet.ProtocolVersion == V5 {
+		packet.Properties, pos, err = decodeProperties(buf, pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// payload
+	packet.ClientID, pos, err = readString(buf, pos)
+	if err != nil {
+		return nil, fmt.Errorf("malformed client identifier")
+	}
+	if packet.WillFlag {
+		if packet.ProtocolVersion == V5 {
+			packet.WillProperties, pos, err = decodeProperties(buf, pos)
+			if err != nil {
+				return nil, err
+			}
+		}
+		packet.WillTopic, pos, err = readString(buf, pos)
+		if err != nil {
+			return nil, fmt.Errorf("malformed will topic")
+		}
+		packet.WillMessage, pos, err = readBinary(buf, pos)
+		if err != nil {
+			return nil, fmt.Errorf("malformed will message")
+		}
+	}
+	if packet.UsernameFlag {
+		packet.Username, pos, err = readString(buf, pos)
+		if err != nil {
+			return nil, fmt.Errorf("malformed user name")
+		}
+	}
+	if packet.PasswordFlag {
+		packet.Password, _, err = readBinary(buf, pos)
+		if err != nil {
+			return nil, fmt.Errorf("malformed password")
+		}
+	}
+	return packet, nil
+}
@@ -0,0 +1,15 @@
+package packets
+
+// packets without variable header or payload
+
+type PingreqPacket struct{}
+
+type PingrespPacket struct{}
+
+func EncodePingreq() []byte {
+	return []byte{0xC0, 0x00}
+}
+
+func EncodePingresp() []byte {
+	return []byte{0xD0, 0x00}
+}
@@ -0,0 +1,47 @@
+package packets
+
+// DisconnectPacket is sent by the client before closing the connection. In
+// MQTT 5 the broker may send one too, with a reason code telling why it is
+// about to close the connection.
+type DisconnectPacket struct {
+	Version    byte        // protocol level, V5 adds reason code and properties
+	ReasonCode byte        // MQTT 5 only
+	Properties *Properties // MQTT 5 only
+}
+
+// EncodeDisconnect returns the two byte 3.1.1 DISCONNECT packet.
+func EncodeDisconnect() []byte {
+	return []byte{0xE0, 0x00}
+}
+
+// EncodeDisconnectPacket encodes a DISCONNECT with reason code and properties when packet.Version is V5.
+func EncodeDisconnectPacket(packet *DisconnectPacket) []byte {
+	// MQTT 5 lets us leave out a normal disconnection without properties
+	if packet.Version != V5 || (packet.ReasonCode == ReasonSuccess && packet.Properties == nil) {
+		return EncodeDisconnect()
+	}
+	variableHeader := []byte{packet.ReasonCode}
+	variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+	return withFixedHeader(0xE0, variableHeader)
+}
+
+// DecodeDisconnect parses a full DISCONNECT packet (starting from fixed header)
+func DecodeDisconnect(data []byte, version byte) (*DisconnectPacket, error) {
+	buf, err := body(data)
+	if err != nil {
+		return nil, err
+	}
+	packet := &DisconnectPacket{Version: version}
+	if version == V5 {
+		if len(buf) > 0 {
+			packet.ReasonCode = buf[0]
+		}
+		if len(buf) > 1 {
+			packet.Properties, _, err = decodeProperties(buf, 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return packet, nil
+}
@@ -0,0 +1,209 @@
+package packets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrTooLarge is returned by ReadPacketMax for a packet over the limit.
+var ErrTooLarge = errors.New("packet exceeds the maximum packet size")
+
+// control packet types (high nibble of the first fixed header byte)
+const (
+	CONNECT     byte = 1
+	CONNACK     byte = 2
+	PUBLISH     byte = 3
+	PUBACK      byte = 4
+	PUBREC      byte = 5
+	PUBREL      byte = 6
+	PUBCOMP     byte = 7
+	SUBSCRIBE   byte = 8
+	SUBACK      byte = 9
+	UNSUBSCRIBE byte = 10
+	UNSUBACK    byte = 11
+	PINGREQ     byte = 12
+	PINGRESP    byte = 13
+	DISCONNECT  byte = 14
+	AUTH        byte = 15 // MQTT 5 only
+)
+
+// protocol levels as sent in CONNECT
+const (
+	V31  byte = 3 // MQTT 3.1, protocol name "MQIsdp"
+	V311 byte = 4 // MQTT 3.1.1
+	V5   byte = 5 // MQTT 5.0
+)
+
+var typeNames = map[byte]string{
+	CONNECT:     "CONNECT",
+	CONNACK:     "CONNACK",
+	PUBLISH:     "PUBLISH",
+	PUBACK:      "PUBACK",
+	PUBREC:      "PUBREC",
+	PUBREL:      "PUBREL",
+	PUBCOMP:     "PUBCOMP",
+	SUBSCRIBE:   "SUBSCRIBE",
+	SUBACK:      "SUBACK",
+	UNSUBSCRIBE: "UNSUBSCRIBE",
+	UNSUBACK:    "UNSUBACK",
+	PINGREQ:     "PINGREQ",
+	PINGRESP:    "PINGRESP",
+	DISCONNECT:  "DISCONNECT",
+	AUTH:        "AUTH",
+}
+
+// TypeName returns the name of a control packet type, e.g. "PUBLISH".
+func TypeName(packetType byte) string {
+	if name, ok := typeNames[packetType]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", packetType)
+}
+
+// Type returns the control packet type of an encoded packet.
+func Type(data []byte) byte {
+	if len(data) == 0 {
+		return 0
+	}
+	return data[0] >> 4
+}
+
+// ReadPacket reads exactly one control packet from r and returns it
+// including the fixed header, so it can be handed to the Decode functions.
+func ReadPacket(r io.Reader) ([]byte, error) {
+	return ReadPacketMax(r, 0)
+}
+
+// ReadPacketMax is ReadPacket for packets of at most max bytes, 0 meaning no
+// limit. A larger packet is rejected with ErrTooLarge as soon as its fixed
+// header is read, before anything is allocated for it; the rest of it is
+// left unread.
+func ReadPacketMax(r io.Reader, max int) ([]byte, error) {
+	header := make([]byte, 1, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	// decode remaining length one byte at a time, keeping the bytes for the caller
+	remaining := 0
+	multiplier := 1
+	b := make([]byte, 1)
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, fmt.Errorf("malformed remaining length")
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		header = append(header, b[0])
+		remaining += int(b[0]&0x7F) * multiplier
+		if b[0]&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	if max > 0 && len(header)+remaining > max {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d allowed", ErrTooLarge, len(header)+remaining, max)
+	}
+	data := make([]byte, len(header)+remaining)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[len(header):]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// decodeLength decodes a variable byte integer and returns it with the number of bytes it used
+func decodeLength(buf []byte) (int, int, error) {
+	value := 0
+	multiplier := 1
+	for i := 0; i < 4; i++ {
+		if i >= len(buf) {
+			return 0, i, fmt.Errorf("malformed variable byte integer")
+		}
+		value += int(buf[i]&0x7F) * multiplier
+		if buf[i]&0x80 == 0 {
+			return value, i + 1, nil
+		}
+		multiplier *= 128
+	}
+	return 0, 4, fmt.Errorf("malformed variable byte integer")
+}
+
+// body strips the fixed header and returns the variable header + payload
+func body(data []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("packet too short")
+	}
+	remaining, n, err := decodeLength(data[1:])
+	if err != nil {
+		return nil, fmt.Errorf("malformed remaining length")
+	}
+	idx := 1 + n
+	if len(data)-idx < remaining {
+		return nil, fmt.Errorf("incomplete packet: need %d bytes, have %d", remaining, len(data)-idx)
+	}
+	return data[idx : idx+remaining], nil
+}
+
+// readString reads a length prefixed UTF-8 string starting at buf[pos] and
+// returns it with the position right after it
+func readString(buf []byte, pos int) (string, int, error) {
+	if pos+2 > len(buf) {
+		return "", pos, fmt.Errorf("missing string length")
+	}
+	length := int(buf[pos])<<8 | int(buf[pos+1])
+	pos += 2
+	if pos+length > len(buf) {
+		return "", pos, fmt.Errorf("string length %d exceeds packet", length)
+	}
+	return string(buf[pos : pos+length]), pos + length, nil
+}
+
+// appendString appends s as a length prefixed UTF-8 string
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)>>8), byte(len(s)&0xFF))
+	return append(buf, s...)
+}
+
+// withFixedHeader wraps a variable header + payload with its fixed header
+func withFixedHeader(header byte, rest []byte) []byte {
+	result := []byte{header}
+	result = append(result, encodeLength(len(rest))...)
+	return append(result, rest...)
+}
+
+// Decode parses any control packet and returns a pointer to its typed struct,
+// e.g. *PublishPacket for a PUBLISH. version is the protocol level negotiated
+// on the connection, since MQTT 5 packets carry extra fields.
+func Decode(data []byte, version byte) (any, error) {
+	switch Type(data) {
+	case CONNECT:
+		return DecodeConnect(data)
+	case CONNACK:
+		return DecodeConnack(data, version)
+	case PUBLISH:
+		return DecodePublish(data, version)
+	case PUBACK, PUBREC, PUBREL, PUBCOMP:
+		return DecodeAck(data, version)
+	case SUBSCRIBE:
+		return DecodeSubscribe(data, version)
+	case SUBACK:
+		return DecodeSuback(data, version)
+	case UNSUBSCRIBE:
+		return DecodeUnsubscribe(data, version)
+	case UNSUBACK:
+		return DecodeUnsuback(data, version)
+	case PINGREQ:
+		return &PingreqPacket{}, nil
+	case PINGRESP:
+		return &PingrespPacket{}, nil
+	case DISCONNECT:
+		return DecodeDisconnect(data, version)
+	case AUTH:
+		return DecodeAuth(data)
+	}
+	return nil, fmt.Errorf("unknown packet type %d", Type(data))
+}
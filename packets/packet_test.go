@@ -0,0 +1,85 @@
+package packets_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gorunriki/mqttc/packets"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		version byte
+		data    []byte
+		packet  any
+	}{
+		{"CONNECT", packets.V311, packets.EncodeConnect(&packets.ConnectPacket{ProtocolName: "MQTT", ProtocolVersion: 4, CleanSession: true, KeepAlive: 60, ClientID: "client-1"}),
+			&packets.ConnectPacket{ProtocolName: "MQTT", ProtocolVersion: 4, CleanSession: true, KeepAlive: 60, ClientID: "client-1"}},
+		{"CONNECT MQTT 3.1", packets.V31, packets.EncodeConnect(&packets.ConnectPacket{ProtocolVersion: packets.V31, CleanSession: true, KeepAlive: 60, ClientID: "client-1"}),
+			&packets.ConnectPacket{ProtocolName: "MQIsdp", ProtocolVersion: packets.V31, CleanSession: true, KeepAlive: 60, ClientID: "client-1"}},
+		{"CONNECT custom protocol name", packets.V311, packets.EncodeConnect(&packets.ConnectPacket{ProtocolName: "MQIsdp", ProtocolVersion: packets.V311, ClientID: "c"}),
+			&packets.ConnectPacket{ProtocolName: "MQIsdp", ProtocolVersion: packets.V311, ClientID: "c"}},
+		{"CONNACK", packets.V311, packets.EncodeConnack(&packets.ConnackPacket{Version: packets.V311, SessionPresent: true, ReturnCode: packets.NotAuthorized}),
+			&packets.ConnackPacket{Version: packets.V311, SessionPresent: true, ReturnCode: packets.NotAuthorized}},
+		{"PUBLISH", packets.V311, packets.EncodePublish(&packets.PublishPacket{Version: packets.V311, QoS: 1, Retain: true, Topic: "a/b", PacketID: 7, Payload: []byte("hello")}),
+			&packets.PublishPacket{Version: packets.V311, QoS: 1, Retain: true, Topic: "a/b", PacketID: 7, Payload: []byte("hello")}},
+		{"PUBREL", packets.V311, packets.EncodeAck(&packets.AckPacket{Version: packets.V311, PacketType: packets.PUBREL, PacketID: 300}),
+			&packets.AckPacket{Version: packets.V311, PacketType: packets.PUBREL, PacketID: 300}},
+		{"SUBSCRIBE", packets.V311, packets.EncodeSubscribe(&packets.SubscribePacket{Version: packets.V311, PacketID: 2, Topics: []packets.Subscription{{Topic: "a/#", QoS: 1}, {Topic: "b/+", QoS: 2}}}),
+			&packets.SubscribePacket{Version: packets.V311, PacketID: 2, Topics: []packets.Subscription{{Topic: "a/#", QoS: 1}, {Topic: "b/+", QoS: 2}}}},
+		{"SUBACK", packets.V311, packets.EncodeSuback(&packets.SubackPacket{Version: packets.V311, PacketID: 2, ReturnCodes: []byte{1, 0x80}}),
+			&packets.SubackPacket{Version: packets.V311, PacketID: 2, ReturnCodes: []byte{1, 0x80}}},
+		{"UNSUBSCRIBE", packets.V311, packets.EncodeUnsubscribe(&packets.UnsubscribePacket{Version: packets.V311, PacketID: 3, Topics: []string{"a/#", "b/+"}}),
+			&packets.UnsubscribePacket{Version: packets.V311, PacketID: 3, Topics: []string{"a/#", "b/+"}}},
+		{"UNSUBACK", packets.V311, packets.EncodeUnsuback(&packets.UnsubackPacket{Version: packets.V311, PacketID: 3}),
+			&packets.UnsubackPacket{Version: packets.V311, PacketID: 3}},
+		{"PINGREQ", packets.V311, packets.EncodePingreq(), &packets.PingreqPacket{}},
+		{"PINGRESP", packets.V311, packets.EncodePingresp(), &packets.PingrespPacket{}},
+		{"DISCONNECT", packets.V311, packets.EncodeDisconnect(), &packets.DisconnectPacket{Version: packets.V311}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := packets.Decode(tc.data, tc.version)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.packet) {
+				t.Errorf("Decode = %+v; want %+v", got, tc.packet)
+			}
+		})
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	long := packets.EncodePublish(&packets.PublishPacket{Topic: "big", Payload: bytes.Repeat([]byte("x"), 300)})
+	stream := append(append(append([]byte{}, long...), packets.EncodePingresp()...), long[:10]...)
+	r := bytes.NewReader(stream)
+
+	first, err := packets.ReadPacket(r)
+	if err != nil || !bytes.Equal(first, long) {
+		t.Fatalf("first ReadPacket = %d bytes, %v; want the %d byte PUBLISH", len(first), err, len(long))
+	}
+	second, err := packets.ReadPacket(r)
+	if err != nil || packets.Type(second) != packets.PINGRESP {
+		t.Fatalf("second ReadPacket = %x, %v; want PINGRESP", second, err)
+	}
+	if _, err := packets.ReadPacket(r); err == nil {
+		t.Fatal("ReadPacket of a truncated packet succeeded")
+	}
+}
+
+func TestReadPacketMax(t *testing.T) {
+	// a PUBLISH claiming 256 MB without sending them is rejected from its header alone
+	huge := bytes.NewReader([]byte{0x30, 0xFF, 0xFF, 0xFF, 0x7F})
+	if _, err := packets.ReadPacketMax(huge, 1024); !errors.Is(err, packets.ErrTooLarge) {
+		t.Errorf("ReadPacketMax of a 256 MB packet = %v; want ErrTooLarge", err)
+	}
+
+	ping := packets.EncodePingresp()
+	if data, err := packets.ReadPacketMax(bytes.NewReader(ping), len(ping)); err != nil || !bytes.Equal(data, ping) {
+		t.Errorf("ReadPacketMax at the limit = %x, %v; want %x", data, err, ping)
+	}
+}
@@ -0,0 +1,282 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MQTT 5 property identifiers
+const (
+	PropPayloadFormatIndicator          byte = 0x01
+	PropMessageExpiryInterval           byte = 0x02
+	PropContentType                     byte = 0x03
+	PropResponseTopic                   byte = 0x08
+	PropCorrelationData                 byte = 0x09
+	PropSubscriptionIdentifier          byte = 0x0B
+	PropSessionExpiryInterval           byte = 0x11
+	PropAssignedClientIdentifier        byte = 0x12
+	PropServerKeepAlive                 byte = 0x13
+	PropAuthenticationMethod            byte = 0x15
+	PropAuthenticationData              byte = 0x16
+	PropRequestProblemInformation       byte = 0x17
+	PropWillDelayInterval               byte = 0x18
+	PropRequestResponseInformation      byte = 0x19
+	PropResponseInformation             byte = 0x1A
+	PropServerReference                 byte = 0x1C
+	PropReasonString                    byte = 0x1F
+	PropReceiveMaximum                  byte = 0x21
+	PropTopicAliasMaximum               byte = 0x22
+	PropTopicAlias                      byte = 0x23
+	PropMaximumQoS                      byte = 0x24
+	PropRetainAvailable                 byte = 0x25
+	PropUserProperty                    byte = 0x26
+	PropMaximumPacketSize               byte = 0x27
+	PropWildcardSubscriptionAvailable   byte = 0x28
+	PropSubscriptionIdentifierAvailable byte = 0x29
+	PropSharedSubscriptionAvailable     byte = 0x2A
+)
+
+// Properties holds the MQTT 5 properties of a packet. Pointer and slice
+// fields are nil and strings are empty when the property is absent; which
+// properties are allowed depends on the packet they are attached to.
+type Properties struct {
+	PayloadFormatIndicator          *byte
+	MessageExpiryInterval           *uint32
+	ContentType                     string
+	ResponseTopic                   string
+	CorrelationData                 []byte
+	SubscriptionIdentifiers         []int // only PUBLISH may carry more than one
+	SessionExpiryInterval           *uint32
+	AssignedClientIdentifier        string
+	ServerKeepAlive                 *uint16
+	AuthenticationMethod            string
+	AuthenticationData              []byte
+	RequestProblemInformation       *byte
+	WillDelayInterval               *uint32
+	RequestResponseInformation      *byte
+	ResponseInformation             string
+	ServerReference                 string
+	ReasonString                    string
+	ReceiveMaximum                  *uint16
+	TopicAliasMaximum               *uint16
+	TopicAlias                      *uint16
+	MaximumQoS                      *byte
+	RetainAvailable                 *byte
+	UserProperties                  []UserProperty
+	MaximumPacketSize               *uint32
+	WildcardSubscriptionAvailable   *byte
+	SubscriptionIdentifierAvailable *byte
+	SharedSubscriptionAvailable     *byte
+}
+
+// UserProperty is a name/value pair; the same name may appear more than once.
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// encodeProperties returns the property length followed by the properties,
+// in identifier order. A nil p encodes as an empty property section.
+func encodeProperties(p *Properties) []byte {
+	var buf []byte
+	if p != nil {
+		buf = appendByteProp(buf, PropPayloadFormatIndicator, p.PayloadFormatIndicator)
+		buf = appendUint32Prop(buf, PropMessageExpiryInterval, p.MessageExpiryInterval)
+		buf = appendStringProp(buf, PropContentType, p.ContentType)
+		buf = appendStringProp(buf, PropResponseTopic, p.ResponseTopic)
+		buf = appendBinaryProp(buf, PropCorrelationData, p.CorrelationData)
+		for _, id := range p.SubscriptionIdentifiers {
+			buf = append(buf, PropSubscriptionIdentifier)
+			buf = append(buf, encodeLength(id)...)
+		}
+		buf = appendUint32Prop(buf, PropSessionExpiryInterval, p.SessionExpiryInterval)
+		buf = appendStringProp(buf, PropAssignedClientIdentifier, p.AssignedClientIdentifier)
+		buf = appendUint16Prop(buf, PropServerKeepAlive, p.ServerKeepAlive)
+		buf = appendStringProp(buf, PropAuthenticationMethod, p.AuthenticationMethod)
+		buf = appendBinaryProp(buf, PropAuthenticationData, p.AuthenticationData)
+		buf = appendByteProp(buf, PropRequestProblemInformation, p.RequestProblemInformation)
+		buf = appendUint32Prop(buf, PropWillDelayInterval, p.WillDelayInterval)
+		buf = appendByteProp(buf, PropRequestResponseInformation, p.RequestResponseInformation)
+		buf = appendStringProp(buf, PropResponseInformation, p.ResponseInformation)
+		buf = appendStringProp(buf, PropServerReference, p.ServerReference)
+		buf = appendStringProp(buf, PropReasonString, p.ReasonString)
+		buf = appendUint16Prop(buf, PropReceiveMaximum, p.ReceiveMaximum)
+		buf = appendUint16Prop(buf, PropTopicAliasMaximum, p.TopicAliasMaximum)
+		buf = appendUint16Prop(buf, PropTopicAlias, p.TopicAlias)
+		buf = appendByteProp(buf, PropMaximumQoS, p.MaximumQoS)
+		buf = appendByteProp(buf, PropRetainAvailable, p.RetainAvailable)
+		for _, up := range p.UserProperties {
+			buf = append(buf, PropUserProperty)
+			buf = appendString(buf, up.Key)
+			buf = appendString(buf, up.Value)
+		}
+		buf = appendUint32Prop(buf, PropMaximumPacketSize, p.MaximumPacketSize)
+		buf = appendByteProp(buf, PropWildcardSubscriptionAvailable, p.WildcardSubscriptionAvailable)
+		buf = appendByteProp(buf, PropSubscriptionIdentifierAvailable, p.SubscriptionIdentifierAvailable)
+		buf = appendByteProp(buf, PropSharedSubscriptionAvailable, p.SharedSubscriptionAvailable)
+	}
+	return append(encodeLength(len(buf)), buf...)
+}
+
+func appendByteProp(buf []byte, id byte, v *byte) []byte {
+	if v == nil {
+		return buf
+	}
+	return append(buf, id, *v)
+}
+
+func appendUint16Prop(buf []byte, id byte, v *uint16) []byte {
+	if v == nil {
+		return buf
+	}
+	return binary.BigEndian.AppendUint16(append(buf, id), *v)
+}
+
+func appendUint32Prop(buf []byte, id byte, v *uint32) []byte {
+	if v == nil {
+		return buf
+	}
+	return binary.BigEndian.AppendUint32(append(buf, id), *v)
+}
+
+func appendStringProp(buf []byte, id byte, v string) []byte {
+	if v == "" {
+		return buf
+	}
+	return appendString(append(buf, id), v)
+}
+
+func appendBinaryProp(buf []byte, id byte, v []byte) []byte {
+	if v == nil {
+		return buf
+	}
+	buf = append(buf, id, byte(len(v)>>8), byte(len(v)&0xFF))
+	return append(buf, v...)
+}
+
+// decodeProperties reads the property section starting at buf[pos] and
+// returns the properties (nil if the section is empty) and the position after it
+func decodeProperties(buf []byte, pos int) (*Properties, int, error) {
+	length, n, err := decodeLength(buf[pos:])
+	if err != nil {
+		return nil, pos, fmt.Errorf("malformed property length")
+	}
+	pos += n
+	end := pos + length
+	if end > len(buf) {
+		return nil, pos, fmt.Errorf("property length %d exceeds packet", length)
+	}
+	if length == 0 {
+		return nil, end, nil
+	}
+
+	p := &Properties{}
+	props := buf[:end]
+	for pos < end {
+		id := props[pos]
+		pos++
+		switch id {
+		case PropPayloadFormatIndicator:
+			p.PayloadFormatIndicator, pos, err = readByteProp(props, pos)
+		case PropRequestProblemInformation:
+			p.RequestProblemInformation, pos, err = readByteProp(props, pos)
+		case PropRequestResponseInformation:
+			p.RequestResponseInformation, pos, err = readByteProp(props, pos)
+		case PropMaximumQoS:
+			p.MaximumQoS, pos, err = readByteProp(props, pos)
+		case PropRetainAvailable:
+			p.RetainAvailable, pos, err = readByteProp(props, pos)
+		case PropWildcardSubscriptionAvailable:
+			p.WildcardSubscriptionAvailable, pos, err = readByteProp(props, pos)
+		case PropSubscriptionIdentifierAvailable:
+			p.SubscriptionIdentifierAvailable, pos, err = readByteProp(props, pos)
+		case PropSharedSubscriptionAvailable:
+			p.SharedSubscriptionAvailable, pos, err = readByteProp(props, pos)
+		case PropServerKeepAlive:
+			p.ServerKeepAlive, pos, err = readUint16Prop(props, pos)
+		case PropReceiveMaximum:
+			p.ReceiveMaximum, pos, err = readUint16Prop(props, pos)
+		case PropTopicAliasMaximum:
+			p.TopicAliasMaximum, pos, err = readUint16Prop(props, pos)
+		case PropTopicAlias:
+			p.TopicAlias, pos, err = readUint16Prop(props, pos)
+		case PropMessageExpiryInterval:
+			p.MessageExpiryInterval, pos, err = readUint32Prop(props, pos)
+		case PropSessionExpiryInterval:
+			p.SessionExpiryInterval, pos, err = readUint32Prop(props, pos)
+		case PropWillDelayInterval:
+			p.WillDelayInterval, pos, err = readUint32Prop(props, pos)
+		case PropMaximumPacketSize:
+			p.MaximumPacketSize, pos, err = readUint32Prop(props, pos)
+		case PropContentType:
+			p.ContentType, pos, err = readString(props, pos)
+		case PropResponseTopic:
+			p.ResponseTopic, pos, err = readString(props, pos)
+		case PropAssignedClientIdentifier:
+			p.AssignedClientIdentifier, pos, err = readString(props, pos)
+		case PropAuthenticationMethod:
+			p.AuthenticationMethod, pos, err = readString(props, pos)
+		case PropResponseInformation:
+			p.ResponseInformation, pos, err = readString(props, pos)
+		case PropServerReference:
+			p.ServerReference, pos, err = readString(props, pos)
+		case PropReasonString:
+			p.ReasonString, pos, err = readString(props, pos)
+		case PropCorrelationData:
+			p.CorrelationData, pos, err = readBinary(props, pos)
+		case PropAuthenticationData:
+			p.AuthenticationData, pos, err = readBinary(props, pos)
+		case PropSubscriptionIdentifier:
+			var id, n int
+			id, n, err = decodeLength(props[pos:])
+			pos += n
+			p.SubscriptionIdentifiers = append(p.SubscriptionIdentifiers, id)
+		case PropUserProperty:
+			var up UserProperty
+			up.Key, pos, err = readString(props, pos)
+			if err == nil {
+				up.Value, pos, err = readString(props, pos)
+			}
+			p.UserProperties = append(p.UserProperties, up)
+		default:
+			return nil, pos, fmt.Errorf("unknown property identifier 0x%02X", id)
+		}
+		if err != nil {
+			return nil, pos, fmt.Errorf("malformed property 0x%02X: %w", id, err)
+		}
+	}
+	return p, end, nil
+}
+
+func readByteProp(buf []byte, pos int) (*byte, int, error) {
+	if pos+1 > len(buf) {
+		return nil, pos, fmt.Errorf("truncated")
+	}
+	v := buf[pos]
+	return &v, pos + 1, nil
+}
+
+func readUint16Prop(buf []byte, pos int) (*uint16, int, error) {
+	if pos+2 > len(buf) {
+		return nil, pos, fmt.Errorf("truncated")
+	}
+	v := binary.BigEndian.Uint16(buf[pos:])
+	return &v, pos + 2, nil
+}
+
+func readUint32Prop(buf []byte, pos int) (*uint32, int, error) {
+	if pos+4 > len(buf) {
+		return nil, pos, fmt.Errorf("truncated")
+	}
+	v := binary.BigEndian.Uint32(buf[pos:])
+	return &v, pos + 4, nil
+}
+
+// readBinary reads length prefixed binary data starting at buf[pos]
+func readBinary(buf []byte, pos int) ([]byte, int, error) {
+	s, next, err := readString(buf, pos)
+	if err != nil {
+		return nil, next, err
+	}
+	return []byte(s), next, nil
+}
@@ -0,0 +1,135 @@
+package packets_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gorunriki/mqttc/packets"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// every property identifier, so a round trip exercises the whole codec
+var allProperties = &packets.Properties{
+	PayloadFormatIndicator:          ptr(byte(1)),
+	MessageExpiryInterval:           ptr(uint32(3600)),
+	ContentType:                     "application/json",
+	ResponseTopic:                   "replies/client-1",
+	CorrelationData:                 []byte{0xCA, 0xFE},
+	SubscriptionIdentifiers:         []int{1, 268435455},
+	SessionExpiryInterval:           ptr(uint32(0xFFFFFFFF)),
+	AssignedClientIdentifier:        "auto-1234",
+	ServerKeepAlive:                 ptr(uint16(30)),
+	AuthenticationMethod:            "SCRAM-SHA-256",
+	AuthenticationData:              []byte("n,,n=user,r=nonce"),
+	RequestProblemInformation:       ptr(byte(0)),
+	WillDelayInterval:               ptr(uint32(10)),
+	RequestResponseInformation:      ptr(byte(1)),
+	ResponseInformation:             "replies/",
+	ServerReference:                 "other-broker:1883",
+	ReasonString:                    "because",
+	ReceiveMaximum:                  ptr(uint16(20)),
+	TopicAliasMaximum:               ptr(uint16(10)),
+	TopicAlias:                      ptr(uint16(3)),
+	MaximumQoS:                      ptr(byte(1)),
+	RetainAvailable:                 ptr(byte(0)),
+	UserProperties:                  []packets.UserProperty{{"region", "eu"}, {"region", "us"}},
+	MaximumPacketSize:               ptr(uint32(1 << 20)),
+	WildcardSubscriptionAvailable:   ptr(byte(1)),
+	SubscriptionIdentifierAvailable: ptr(byte(1)),
+	SharedSubscriptionAvailable:     ptr(byte(0)),
+}
+
+func TestRoundTripV5(t *testing.T) {
+	props := &packets.Properties{ReasonString: "ok", UserProperties: []packets.UserProperty{{"k", "v"}}}
+	tests := []struct {
+		name   string
+		data   []byte
+		packet any
+	}{
+		{"CONNECT", packets.EncodeConnect(&packets.ConnectPacket{ProtocolName: "MQTT", ProtocolVersion: packets.V5, CleanSession: true, KeepAlive: 30, ClientID: "c",
+			WillFlag: true, WillQoS: 1, WillRetain: true, WillTopic: "will", WillMessage: []byte("gone"), WillProperties: &packets.Properties{WillDelayInterval: ptr(uint32(5))},
+			UsernameFlag: true, Username: "user", PasswordFlag: true, Password: []byte("secret"), Properties: allProperties}),
+			&packets.ConnectPacket{ProtocolName: "MQTT", ProtocolVersion: packets.V5, CleanSession: true, KeepAlive: 30, ClientID: "c",
+				WillFlag: true, WillQoS: 1, WillRetain: true, WillTopic: "will", WillMessage: []byte("gone"), WillProperties: &packets.Properties{WillDelayInterval: ptr(uint32(5))},
+				UsernameFlag: true, Username: "user", PasswordFlag: true, Password: []byte("secret"), Properties: allProperties}},
+		{"CONNACK", packets.EncodeConnack(&packets.ConnackPacket{Version: packets.V5, ReturnCode: packets.ReasonBanned, Properties: props}),
+			&packets.ConnackPacket{Version: packets.V5, ReturnCode: packets.ReasonBanned, Properties: props}},
+		{"PUBLISH", packets.EncodePublish(&packets.PublishPacket{Version: packets.V5, QoS: 2, Topic: "a", PacketID: 9, Properties: allProperties, Payload: []byte("x")}),
+			&packets.PublishPacket{Version: packets.V5, QoS: 2, Topic: "a", PacketID: 9, Properties: allProperties, Payload: []byte("x")}},
+		{"PUBLISH without properties", packets.EncodePublish(&packets.PublishPacket{Version: packets.V5, Topic: "a", Payload: []byte("x")}),
+			&packets.PublishPacket{Version: packets.V5, Topic: "a", Payload: []byte("x")}},
+		{"PUBACK", packets.EncodeAck(&packets.AckPacket{Version: packets.V5, PacketType: packets.PUBACK, PacketID: 9, ReasonCode: packets.ReasonQuotaExceeded, Properties: props}),
+			&packets.AckPacket{Version: packets.V5, PacketType: packets.PUBACK, PacketID: 9, ReasonCode: packets.ReasonQuotaExceeded, Properties: props}},
+		{"PUBCOMP", packets.EncodeAck(&packets.AckPacket{Version: packets.V5, PacketType: packets.PUBCOMP, PacketID: 9, ReasonCode: packets.ReasonPacketIdentifierNotFound}),
+			&packets.AckPacket{Version: packets.V5, PacketType: packets.PUBCOMP, PacketID: 9, ReasonCode: packets.ReasonPacketIdentifierNotFound}},
+		{"SUBSCRIBE", packets.EncodeSubscribe(&packets.SubscribePacket{Version: packets.V5, PacketID: 2, Properties: props, Topics: []packets.Subscription{{Topic: "a/#", QoS: 1}}}),
+			&packets.SubscribePacket{Version: packets.V5, PacketID: 2, Properties: props, Topics: []packets.Subscription{{Topic: "a/#", QoS: 1}}}},
+		{"SUBSCRIBE with options", packets.EncodeSubscribe(&packets.SubscribePacket{Version: packets.V5, PacketID: 2, Properties: &packets.Properties{SubscriptionIdentifiers: []int{7}}, Topics: []packets.Subscription{{Topic: "a/#", QoS: 2, NoLocal: true, RetainAsPublished: true, RetainHandling: packets.RetainDoNotSend}, {Topic: "b", RetainHandling: packets.RetainSendIfNew}}}),
+			&packets.SubscribePacket{Version: packets.V5, PacketID: 2, Properties: &packets.Properties{SubscriptionIdentifiers: []int{7}}, Topics: []packets.Subscription{{Topic: "a/#", QoS: 2, NoLocal: true, RetainAsPublished: true, RetainHandling: packets.RetainDoNotSend}, {Topic: "b", RetainHandling: packets.RetainSendIfNew}}}},
+		{"SUBACK", packets.EncodeSuback(&packets.SubackPacket{Version: packets.V5, PacketID: 2, Properties: props, ReturnCodes: []byte{packets.ReasonGrantedQoS1, packets.ReasonNotAuthorized}}),
+			&packets.SubackPacket{Version: packets.V5, PacketID: 2, Properties: props, ReturnCodes: []byte{packets.ReasonGrantedQoS1, packets.ReasonNotAuthorized}}},
+		{"UNSUBSCRIBE", packets.EncodeUnsubscribe(&packets.UnsubscribePacket{Version: packets.V5, PacketID: 3, Properties: props, Topics: []string{"a/#"}}),
+			&packets.UnsubscribePacket{Version: packets.V5, PacketID: 3, Properties: props, Topics: []string{"a/#"}}},
+		{"UNSUBACK", packets.EncodeUnsuback(&packets.UnsubackPacket{Version: packets.V5, PacketID: 3, ReasonCodes: []byte{packets.ReasonNoSubscriptionExisted}}),
+			&packets.UnsubackPacket{Version: packets.V5, PacketID: 3, ReasonCodes: []byte{packets.ReasonNoSubscriptionExisted}}},
+		{"DISCONNECT", packets.EncodeDisconnectPacket(&packets.DisconnectPacket{Version: packets.V5, ReasonCode: packets.ReasonServerShuttingDown, Properties: &packets.Properties{ServerReference: "b2"}}),
+			&packets.DisconnectPacket{Version: packets.V5, ReasonCode: packets.ReasonServerShuttingDown, Properties: &packets.Properties{ServerReference: "b2"}}},
+		{"AUTH", packets.EncodeAuth(&packets.AuthPacket{ReasonCode: packets.ReasonContinueAuthentication, Properties: &packets.Properties{AuthenticationMethod: "SCRAM-SHA-256", AuthenticationData: []byte("r=abc")}}),
+			&packets.AuthPacket{ReasonCode: packets.ReasonContinueAuthentication, Properties: &packets.Properties{AuthenticationMethod: "SCRAM-SHA-256", AuthenticationData: []byte("r=abc")}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := packets.Decode(tc.data, packets.V5)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.packet) {
+				t.Errorf("Decode =\n%+v\nwant\n%+v", got, tc.packet)
+			}
+		})
+	}
+}
+
+func TestShortFormV5(t *testing.T) {
+	// success without properties leaves out the reason code entirely
+	ack := packets.EncodeAck(&packets.AckPacket{Version: packets.V5, PacketType: packets.PUBACK, PacketID: 1})
+	if len(ack) != 4 {
+		t.Errorf("PUBACK with success = %x; want the 4 byte short form", ack)
+	}
+	disconnect := packets.EncodeDisconnectPacket(&packets.DisconnectPacket{Version: packets.V5})
+	if len(disconnect) != 2 {
+		t.Errorf("normal DISCONNECT = %x; want the 2 byte short form", disconnect)
+	}
+}
+
+func TestDecodeUnknownProperty(t *testing.T) {
+	// PUBLISH "a" with property length 2 and the unused identifier 0x05
+	data := []byte{0x30, 0x06, 0x00, 0x01, 'a', 0x02, 0x05, 0x00}
+	if _, err := packets.DecodePublish(data, packets.V5); err == nil {
+		t.Error("DecodePublish accepted an unknown property identifier")
+	}
+}
+
+func TestSubscriptionOptions(t *testing.T) {
+	sub := []packets.Subscription{{Topic: "a", QoS: 1, NoLocal: true, RetainAsPublished: true, RetainHandling: packets.RetainSendIfNew}}
+
+	// the options byte follows the topic filter
+	v5 := packets.EncodeSubscribe(&packets.SubscribePacket{Version: packets.V5, PacketID: 1, Topics: sub})
+	if got := v5[len(v5)-1]; got != 0x1D {
+		t.Errorf("MQTT 5 options byte = 0x%02X; want 0x1D", got)
+	}
+	v311 := packets.EncodeSubscribe(&packets.SubscribePacket{Version: packets.V311, PacketID: 1, Topics: sub})
+	if got := v311[len(v311)-1]; got != 0x01 {
+		t.Errorf("MQTT 3.1.1 options byte = 0x%02X; want 0x01", got)
+	}
+
+	for _, opts := range []byte{0x40, 0x30} {
+		bad := append(v5[:len(v5)-1:len(v5)-1], opts)
+		if _, err := packets.DecodeSubscribe(bad, packets.V5); err == nil {
+			t.Errorf("DecodeSubscribe with options 0x%02X succeeded; want an error", opts)
+		}
+	}
+}
@@ -3,12 +3,14 @@ package packets
 import "fmt"
 
 type PublishPacket struct {
-	Dup      bool
-	QoS      byte
-	Retain   bool
-	Topic    string
-	PacketID uint16
-	Payload  []byte
+	Version    byte // protocol level, V5 adds properties
+	Dup        bool
+	QoS        byte
+	Retain     bool
+	Topic      string
+	PacketID   uint16
+	Properties *Properties // MQTT 5 only
+	Payload    []byte
 }
 
 func EncodePublish(packet *PublishPacket) []byte {
@@ -37,6 +39,11 @@ func EncodePublish(packet *PublishPacket) []byte {
 		variableHeader = append(variableHeader, byte(packet.PacketID>>8), byte(packet.PacketID&0xFF)) // packet ID (2 bytes, big endian)
 	}
 
+	// add properties
+	if packet.Version == V5 {
+		variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+	}
+
 	// add payload
 	payload := packet.Payload
 
@@ -51,9 +58,8 @@ func EncodePublish(packet *PublishPacket) []byte {
 
 // DecodePublish parses a full PUBLISH packet (starting from fixed header)
 // `data` must contain the fixed header byte(s) and the remaining length and remaining bytes.
-func DecodePublish(data []byte) (*PublishPacket, error) {
-	fmt.Println(string(data)) // view data content for debugging
-
+// `version` is the protocol level of the connection, V5 packets carry properties.
+func DecodePublish(data []byte, version byte) (*PublishPacket, error) {
 	// need at least the first fixed header byte + one remaining-length byte
 	// check the packet length
 	if len(data) < 2 {
@@ -123,18 +129,30 @@ func DecodePublish(data []byte) (*PublishPacket, error) {
 		pos += 2
 	}
 
+	// MQTT 5 properties sit between the variable header and the payload
+	var props *Properties
+	if version == V5 {
+		var err error
+		props, pos, err = decodeProperties(buf, pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// The remainder of buf is the application payload
 	payload := make([]byte, len(buf)-pos)
 	copy(payload, buf[pos:])
 
 	// Build and return the parsed PublishPacket
 	pkt := &PublishPacket{
-		Dup:      dup,
-		QoS:      qos,
-		Retain:   retain,
-		Topic:    topic,
-		PacketID: packetID,
-		Payload:  payload,
+		Version:    version,
+		Dup:        dup,
+		QoS:        qos,
+		Retain:     retain,
+		Topic:      topic,
+		PacketID:   packetID,
+		Properties: props,
+		Payload:    payload,
 	}
 	return pkt, nil
 }
@@ -0,0 +1,104 @@
+package packets
+
+import "fmt"
+
+// MQTT 5 reason codes. Codes below 0x80 report success, 0x80 and above are errors.
+const (
+	ReasonSuccess                             byte = 0x00 // also Normal disconnection and Granted QoS 0
+	ReasonGrantedQoS1                         byte = 0x01
+	ReasonGrantedQoS2                         byte = 0x02
+	ReasonDisconnectWithWillMessage           byte = 0x04
+	ReasonNoMatchingSubscribers               byte = 0x10
+	ReasonNoSubscriptionExisted               byte = 0x11
+	ReasonContinueAuthentication              byte = 0x18
+	ReasonReAuthenticate                      byte = 0x19
+	ReasonUnspecifiedError                    byte = 0x80
+	ReasonMalformedPacket                     byte = 0x81
+	ReasonProtocolError                       byte = 0x82
+	ReasonImplementationSpecificError         byte = 0x83
+	ReasonUnsupportedProtocolVersion          byte = 0x84
+	ReasonClientIdentifierNotValid            byte = 0x85
+	ReasonBadUserNameOrPassword               byte = 0x86
+	ReasonNotAuthorized                       byte = 0x87
+	ReasonServerUnavailable                   byte = 0x88
+	ReasonServerBusy                          byte = 0x89
+	ReasonBanned                              byte = 0x8A
+	ReasonServerShuttingDown                  byte = 0x8B
+	ReasonBadAuthenticationMethod             byte = 0x8C
+	ReasonKeepAliveTimeout                    byte = 0x8D
+	ReasonSessionTakenOver                    byte = 0x8E
+	ReasonTopicFilterInvalid                  byte = 0x8F
+	ReasonTopicNameInvalid                    byte = 0x90
+	ReasonPacketIdentifierInUse               byte = 0x91
+	ReasonPacketIdentifierNotFound            byte = 0x92
+	ReasonReceiveMaximumExceeded              byte = 0x93
+	ReasonTopicAliasInvalid                   byte = 0x94
+	ReasonPacketTooLarge                      byte = 0x95
+	ReasonMessageRateTooHigh                  byte = 0x96
+	ReasonQuotaExceeded                       byte = 0x97
+	ReasonAdministrativeAction                byte = 0x98
+	ReasonPayloadFormatInvalid                byte = 0x99
+	ReasonRetainNotSupported                  byte = 0x9A
+	ReasonQoSNotSupported                     byte = 0x9B
+	ReasonUseAnotherServer                    byte = 0x9C
+	ReasonServerMoved                         byte = 0x9D
+	ReasonSharedSubscriptionsNotSupported     byte = 0x9E
+	ReasonConnectionRateExceeded              byte = 0x9F
+	ReasonMaximumConnectTime                  byte = 0xA0
+	ReasonSubscriptionIdentifiersNotSupported byte = 0xA1
+	ReasonWildcardSubscriptionsNotSupported   byte = 0xA2
+)
+
+var reasonNames = map[byte]string{
+	ReasonSuccess:                             "success",
+	ReasonGrantedQoS1:                         "granted QoS 1",
+	ReasonGrantedQoS2:                         "granted QoS 2",
+	ReasonDisconnectWithWillMessage:           "disconnect with will message",
+	ReasonNoMatchingSubscribers:               "no matching subscribers",
+	ReasonNoSubscriptionExisted:               "no subscription existed",
+	ReasonContinueAuthentication:              "continue authentication",
+	ReasonReAuthenticate:                      "re-authenticate",
+	ReasonUnspecifiedError:                    "unspecified error",
+	ReasonMalformedPacket:                     "malformed packet",
+	ReasonProtocolError:                       "protocol error",
+	ReasonImplementationSpecificError:         "implementation specific error",
+	ReasonUnsupportedProtocolVersion:          "unsupported protocol version",
+	ReasonClientIdentifierNotValid:            "client identifier not valid",
+	ReasonBadUserNameOrPassword:               "bad user name or password",
+	ReasonNotAuthorized:                       "not authorized",
+	ReasonServerUnavailable:                   "server unavailable",
+	ReasonServerBusy:                          "server busy",
+	ReasonBanned:                              "banned",
+	ReasonServerShuttingDown:                  "server shutting down",
+	ReasonBadAuthenticationMethod:             "bad authentication method",
+	ReasonKeepAliveTimeout:                    "keep alive timeout",
+	ReasonSessionTakenOver:                    "session taken over",
+	ReasonTopicFilterInvalid:                  "topic filter invalid",
+	ReasonTopicNameInvalid:                    "topic name invalid",
+	ReasonPacketIdentifierInUse:               "packet identifier in use",
+	ReasonPacketIdentifierNotFound:            "packet identifier not found",
+	ReasonReceiveMaximumExceeded:              "receive maximum exceeded",
+	ReasonTopicAliasInvalid:                   "topic alias invalid",
+	ReasonPacketTooLarge:                      "packet too large",
+	ReasonMessageRateTooHigh:                  "message rate too high",
+	ReasonQuotaExceeded:                       "quota exceeded",
+	ReasonAdministrativeAction:                "administrative action",
+	ReasonPayloadFormatInvalid:                "payload format invalid",
+	ReasonRetainNotSupported:                  "retain not supported",
+	ReasonQoSNotSupported:                     "QoS not supported",
+	ReasonUseAnotherServer:                    "use another server",
+	ReasonServerMoved:                         "server moved",
+	ReasonSharedSubscriptionsNotSupported:     "shared subscriptions not supported",
+	ReasonConnectionRateExceeded:              "connection rate exceeded",
+	ReasonMaximumConnectTime:                  "maximum connect time",
+	ReasonSubscriptionIdentifiersNotSupported: "subscription identifiers not supported",
+	ReasonWildcardSubscriptionsNotSupported:   "wildcard subscriptions not supported",
+}
+
+// ReasonName returns the description of an MQTT 5 reason code.
+func ReasonName(code byte) string {
+	if name, ok := reasonNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("reason code 0x%02X", code)
+}
@@ -3,19 +3,39 @@ package packets
 import "fmt"
 
 type SubackPacket struct {
+	Version     byte // protocol level, V5 adds properties
 	PacketID    uint16
-	ReturnCodes []byte
+	Properties  *Properties // MQTT 5 only
+	ReturnCodes []byte      // granted QoS or failure (0x80), one reason code per filter in MQTT 5
 }
 
-func DecodeSuback(data []byte) (*SubackPacket, error) {
-	if len(data) < 4 || data[0] != 0x90 {
+func EncodeSuback(packet *SubackPacket) []byte {
+	variableHeader := []byte{byte(packet.PacketID >> 8), byte(packet.PacketID & 0xFF)}
+	if packet.Version == V5 {
+		variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+	}
+	variableHeader = append(variableHeader, packet.ReturnCodes...)
+	return withFixedHeader(0x90, variableHeader)
+}
+
+// DecodeSuback parses a full SUBACK packet (starting from fixed header)
+func DecodeSuback(data []byte, version byte) (*SubackPacket, error) {
+	buf, err := body(data)
+	if err != nil {
+		return nil, err
+	}
+	if Type(data) != SUBACK || len(buf) < 2 {
 		return nil, fmt.Errorf("SUBACK too short")
 	}
-	packetID := uint16(data[2])<<8 | uint16(data[3])
-	returnCodes := data[4:]
 
-	return &SubackPacket{
-		PacketID:    packetID,
-		ReturnCodes: returnCodes,
-	}, nil
+	packet := &SubackPacket{Version: version, PacketID: uint16(buf[0])<<8 | uint16(buf[1])}
+	pos := 2
+	if version == V5 {
+		packet.Properties, pos, err = decodeProperties(buf, pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+	packet.ReturnCodes = append([]byte{}, buf[pos:]...)
+	return packet, nil
 }
@@ -1,13 +1,44 @@
 package packets
 
+import "fmt"
+
 type SubscribePacket struct {
-	PacketID uint16
-	Topics   []Subscription
+	Version    byte // protocol level, V5 adds properties
+	PacketID   uint16
+	Properties *Properties // MQTT 5 only
+	Topics     []Subscription
 }
 
+// Subscription is one topic filter of a SUBSCRIBE packet. The fields after
+// QoS are MQTT 5 subscription options and are not sent with MQTT 3.1.1.
 type Subscription struct {
-	Topic string
-	QoS   byte
+	Topic             string
+	QoS               byte
+	NoLocal           bool // do not send our own publishes back to us
+	RetainAsPublished bool // keep the retain flag of forwarded messages
+	RetainHandling    byte // one of the Retain* constants below
+}
+
+// MQTT 5 retain handling options, when to send retained messages on subscribe
+const (
+	RetainSendOnSubscribe byte = 0 // on every subscribe
+	RetainSendIfNew       byte = 1 // only if the subscription did not exist yet
+	RetainDoNotSend       byte = 2 // never
+)
+
+// options returns the subscription options byte for the given protocol level
+func (s Subscription) options(version byte) byte {
+	opts := s.QoS
+	if version == V5 {
+		if s.NoLocal {
+			opts |= 0x04
+		}
+		if s.RetainAsPublished {
+			opts |= 0x08
+		}
+		opts |= s.RetainHandling << 4
+	}
+	return opts
 }
 
 func EncodeSubscribe(packet *SubscribePacket) []byte {
@@ -15,13 +46,16 @@ func EncodeSubscribe(packet *SubscribePacket) []byte {
 
 	// variable header
 	variableHeader := []byte{byte(packet.PacketID >> 8), byte(packet.PacketID & 0xFF)} //packet ID (2bytes, big endian)
+	if packet.Version == V5 {
+		variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+	}
 
 	// payload
 	payload := []byte{}
 	for _, sub := range packet.Topics {
 		payload = append(payload, byte(len(sub.Topic)>>8), byte(len(sub.Topic)&0xFF)) // topic length (2 bytes, big endian)
 		payload = append(payload, []byte(sub.Topic)...)                               // append topic
-		payload = append(payload, sub.QoS)                                            // append QoS byte
+		payload = append(payload, sub.options(packet.Version))                        // append subscription options
 	}
 
 	// calculate remaining length
@@ -32,3 +66,50 @@ func EncodeSubscribe(packet *SubscribePacket) []byte {
 
 	return result
 }
+
+// DecodeSubscribe parses a full SUBSCRIBE packet (starting from fixed header)
+func DecodeSubscribe(data []byte, version byte) (*SubscribePacket, error) {
+	buf, err := body(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("missing packet identifier")
+	}
+
+	packet := &SubscribePacket{Version: version, PacketID: uint16(buf[0])<<8 | uint16(buf[1])}
+	pos := 2
+	if version == V5 {
+		packet.Properties, pos, err = decodeProperties(buf, pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for pos < len(buf) {
+		if pos+2 > len(buf) {
+			return nil, fmt.Errorf("malformed topic length")
+		}
+		topicLen := int(buf[pos])<<8 | int(buf[pos+1])
+		pos += 2
+		if pos+topicLen+1 > len(buf) {
+			return nil, fmt.Errorf("malformed topic filter")
+		}
+		sub := Subscription{Topic: string(buf[pos : pos+topicLen])}
+		opts := buf[pos+topicLen]
+		sub.QoS = opts & 0x03
+		if version == V5 {
+			if opts&0xC0 != 0 {
+				return nil, fmt.Errorf("reserved subscription option bits set")
+			}
+			sub.NoLocal = opts&0x04 != 0
+			sub.RetainAsPublished = opts&0x08 != 0
+			sub.RetainHandling = opts >> 4 & 0x03
+			if sub.RetainHandling > RetainDoNotSend {
+				return nil, fmt.Errorf("invalid retain handling %d", sub.RetainHandling)
+			}
+		}
+		packet.Topics = append(packet.Topics, sub)
+		pos += topicLen + 1
+	}
+	return packet, nil
+}
@@ -0,0 +1,91 @@
+package packets
+
+import "fmt"
+
+type UnsubscribePacket struct {
+	Version    byte // protocol level, V5 adds properties
+	PacketID   uint16
+	Properties *Properties // MQTT 5 only
+	Topics     []string
+}
+
+func EncodeUnsubscribe(packet *UnsubscribePacket) []byte {
+	// variable header
+	variableHeader := []byte{byte(packet.PacketID >> 8), byte(packet.PacketID & 0xFF)}
+	if packet.Version == V5 {
+		variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+	}
+
+	// payload
+	for _, topic := range packet.Topics {
+		variableHeader = appendString(variableHeader, topic)
+	}
+
+	return withFixedHeader(0xA2, variableHeader) // 1010 0010 (UNSUBSCRIBE packet)
+}
+
+// DecodeUnsubscribe parses a full UNSUBSCRIBE packet (starting from fixed header)
+func DecodeUnsubscribe(data []byte, version byte) (*UnsubscribePacket, error) {
+	buf, err := body(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("missing packet identifier")
+	}
+
+	packet := &UnsubscribePacket{Version: version, PacketID: uint16(buf[0])<<8 | uint16(buf[1])}
+	pos := 2
+	if version == V5 {
+		packet.Properties, pos, err = decodeProperties(buf, pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for pos < len(buf) {
+		var topic string
+		topic, pos, err = readString(buf, pos)
+		if err != nil {
+			return nil, fmt.Errorf("malformed topic filter")
+		}
+		packet.Topics = append(packet.Topics, topic)
+	}
+	return packet, nil
+}
+
+type UnsubackPacket struct {
+	Version     byte // protocol level, V5 adds properties and reason codes
+	PacketID    uint16
+	Properties  *Properties // MQTT 5 only
+	ReasonCodes []byte      // MQTT 5 only, one per topic filter
+}
+
+func EncodeUnsuback(packet *UnsubackPacket) []byte {
+	variableHeader := []byte{byte(packet.PacketID >> 8), byte(packet.PacketID & 0xFF)}
+	if packet.Version == V5 {
+		variableHeader = append(variableHeader, encodeProperties(packet.Properties)...)
+		variableHeader = append(variableHeader, packet.ReasonCodes...)
+	}
+	return withFixedHeader(0xB0, variableHeader)
+}
+
+// DecodeUnsuback parses a full UNSUBACK packet (starting from fixed header)
+func DecodeUnsuback(data []byte, version byte) (*UnsubackPacket, error) {
+	buf, err := body(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("missing packet identifier")
+	}
+	packet := &UnsubackPacket{Version: version, PacketID: uint16(buf[0])<<8 | uint16(buf[1])}
+	if version == V5 {
+		var pos int
+		packet.Properties, pos, err = decodeProperties(buf, 2)
+		if err != nil {
+			return nil, err
+		}
+		packet.ReasonCodes = append([]byte{}, buf[pos:]...)
+	}
+	return packet, nil
+}
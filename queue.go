@@ -0,0 +1,149 @@
+package mqttc
+
+import (
+	"hash/maphash"
+
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// topicSeed spreads topics over the handler workers
+var topicSeed = maphash.MakeSeed()
+
+// HandlerMode decides how many messages are handled at once, see WithHandlerMode.
+type HandlerMode int
+
+const (
+	// PerTopic handles messages on as many goroutines as WithHandlerWorkers
+	// allows, in order per topic. With one worker, the default, this is the
+	// same as Ordered.
+	PerTopic HandlerMode = iota
+	// Ordered handles one message at a time, in the order they arrived.
+	Ordered
+)
+
+// AckTiming decides when a QoS 1 or 2 message is acknowledged, see WithHandlerMode.
+type AckTiming int
+
+const (
+	// AckAfterHandler acknowledges once the handler has returned, so a message
+	// lost to a crash while being handled is sent again by the broker.
+	AckAfterHandler AckTiming = iota
+	// AckOnReceipt acknowledges as soon as the message is read, before it is
+	// queued, which lets the broker send the next ones sooner.
+	AckOnReceipt
+	// AckManual leaves acknowledging to the application, see Message.Ack.
+	// Until then the message counts against the broker's Receive Maximum.
+	// Messages the application never sees, such as replies to Request or
+	// messages dropped from a full queue, are acknowledged by the client, and
+	// SubscribeTyped acknowledges once its handler has returned.
+	AckManual
+)
+
+// Stats are counters on the handling of incoming messages.
+type Stats struct {
+	Queued  int    // messages waiting for a handler
+	Dropped uint64 // messages discarded because a queue or SubscribeChan channel was full, since NewClient
+}
+
+// Stats returns the current counters.
+func (c *Client) Stats() Stats {
+	stats := Stats{Dropped: c.droppedIncoming.Load()}
+	for _, queue := range c.queues {
+		stats.Queued += len(queue)
+	}
+	return stats
+}
+
+// enqueue queues publish for the handler worker of its topic, applying the
+// overflow policy when the queue is full. It returns false when the
+// connection ended while waiting for room.
+func (c *Client) enqueue(publish *packets.PublishPacket) bool {
+	if c.ackTiming == AckOnReceipt {
+		c.ack(publish)
+	}
+
+	queue := c.queues[0]
+	if len(c.queues) > 1 {
+		// the same topic always goes to the same worker, which keeps its messages in order
+		queue = c.queues[maphash.String(topicSeed, publish.Topic)%uint64(len(c.queues))]
+	}
+
+	select {
+	case queue <- publish:
+		return true
+	default:
+	}
+
+	switch c.overflow {
+	case DropNewest:
+		c.drop(publish)
+	case DropOldest:
+		for {
+			select {
+			case old := <-queue:
+				c.drop(old)
+			default:
+			}
+			select {
+			case queue <- publish:
+				return true
+			default: // the worker took the free slot first, try again
+			}
+		}
+	default:
+		// blocks reading, including PINGRESP, until the handler catches up
+		select {
+		case queue <- publish:
+		case <-c.done:
+			return false
+		}
+	}
+	return true
+}
+
+// drop discards an incoming message. QoS 1 and 2 messages are still
+// acknowledged, or the broker would hold them in flight forever.
+func (c *Client) drop(publish *packets.PublishPacket) {
+	c.droppedIncoming.Add(1)
+	c.logger.Warn("incoming queue full, message dropped", "topic", publish.Topic, "qos", publish.QoS)
+	if c.ackTiming != AckOnReceipt {
+		c.ack(publish)
+	}
+}
+
+// ack acknowledges a QoS 1 or 2 message
+func (c *Client) ack(publish *packets.PublishPacket) error {
+	switch publish.QoS {
+	case 1:
+		return c.sendAck(packets.PUBACK, publish.PacketID)
+	case 2:
+		return c.sendAck(packets.PUBREC, publish.PacketID) // the broker answers with PUBREL
+	}
+	return nil
+}
+
+// processMessages is a handler worker, delivering the messages of one queue
+// in order
+func (c *Client) processMessages(queue chan *packets.PublishPacket) {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case publish := <-queue:
+			c.deliver(publish)
+
+		case <-c.done:
+			// readLoop can no longer queue anything once it has returned,
+			// so whatever is left in the queue is the complete backlog
+			<-c.readDone
+			for {
+				select {
+				case publish := <-queue:
+					c.deliver(publish)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
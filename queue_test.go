@@ -0,0 +1,220 @@
+package mqttc_test
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// blockingHandler returns a handler that records payloads and, for the first
+// message, signals started and waits for release
+func blockingHandler(received chan<- string) (handler mqttc.MessageHandler, started, release chan struct{}) {
+	started = make(chan struct{})
+	release = make(chan struct{})
+	var once sync.Once
+	return func(topic string, payload []byte) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		received <- string(payload)
+	}, started, release
+}
+
+func TestIncomingQueueOverflow(t *testing.T) {
+	tests := []struct {
+		overflow mqttc.Overflow
+		want     string // the message left in the queue
+	}{
+		{mqttc.DropNewest, "1"},
+		{mqttc.DropOldest, "4"},
+	}
+	for _, tc := range tests {
+		b := newFakeBroker(t)
+		client := mqttc.NewClient(b.addr(), "overflow", mqttc.WithIncomingQueue(1, tc.overflow))
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		received := make(chan string, 10)
+		handler, started, release := blockingHandler(received)
+		client.SetMessageHandler(handler)
+		if err := client.Subscribe("queue"); err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+
+		if err := client.Publish("queue", "0"); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		<-started
+		// the handler is stuck, yet acknowledgements are still read
+		for i := 1; i <= 4; i++ {
+			if err := client.Publish("queue", strconv.Itoa(i), mqttc.PublishQoS(1)); err != nil {
+				t.Fatalf("Publish with a blocked handler: %v", err)
+			}
+		}
+		waitFor(t, func() bool { return client.Stats().Dropped == 3 })
+		if got := client.Stats(); got.Queued != 1 {
+			t.Errorf("overflow %d: Stats = %+v; want 1 queued", tc.overflow, got)
+		}
+
+		close(release)
+		for _, want := range []string{"0", tc.want} {
+			select {
+			case got := <-received:
+				if got != want {
+					t.Errorf("overflow %d: received %q; want %q", tc.overflow, got, want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("timed out waiting for message")
+			}
+		}
+		client.Disconnect()
+	}
+}
+
+func TestHandlerWorkers(t *testing.T) {
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "workers", mqttc.WithHandlerWorkers(4))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	var mu sync.Mutex
+	perTopic := make(map[string][]int)
+	other := make(chan struct{}, 100)
+	release := make(chan struct{})
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		if topic == "slow" {
+			<-release
+			return
+		}
+		n, _ := strconv.Atoi(string(payload))
+		mu.Lock()
+		perTopic[topic] = append(perTopic[topic], n)
+		mu.Unlock()
+		other <- struct{}{}
+	})
+	if err := client.Subscribe("#"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// a stuck topic only holds up its own worker
+	if err := client.Publish("slow", "x"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	const topics, each = 20, 10
+	for i := range each {
+		for topic := range topics {
+			if err := client.Publish("t/"+strconv.Itoa(topic), strconv.Itoa(i)); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+		}
+	}
+	select {
+	case <-other:
+	case <-time.After(2 * time.Second):
+		t.Fatal("a blocked handler held up every worker")
+	}
+	close(release)
+	for range topics*each - 1 {
+		select {
+		case <-other:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for topic, got := range perTopic {
+		for i, n := range got {
+			if n != i {
+				t.Fatalf("%s handled in order %v; want 0 to %d", topic, got, each-1)
+			}
+		}
+	}
+}
+
+func TestOrderedHandlerMode(t *testing.T) {
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "ordered", mqttc.WithHandlerWorkers(4), mqttc.WithHandlerMode(mqttc.Ordered, mqttc.AckAfterHandler))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	var running atomic.Int32
+	received := make(chan string, 100)
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		if running.Add(1) > 1 {
+			t.Error("handler called concurrently in Ordered mode")
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		received <- string(payload)
+	})
+	if err := client.Subscribe("#"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// messages on different topics still come out in the order they went in
+	const total = 20
+	for i := range total {
+		if err := client.Publish("t/"+strconv.Itoa(i%5), strconv.Itoa(i)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	for i := range total {
+		select {
+		case got := <-received:
+			if got != strconv.Itoa(i) {
+				t.Fatalf("message %d was %q", i, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+}
+
+func TestAckTiming(t *testing.T) {
+	tests := []struct {
+		ack         mqttc.AckTiming
+		whileHandle int // PUBACKs the broker has while the handler runs
+	}{
+		{mqttc.AckAfterHandler, 0},
+		{mqttc.AckOnReceipt, 1},
+	}
+	for _, tc := range tests {
+		b := newFakeBroker(t)
+		client := mqttc.NewClient(b.addr(), "acktiming", mqttc.WithHandlerMode(mqttc.PerTopic, tc.ack))
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		received := make(chan string, 1)
+		handler, started, release := blockingHandler(received)
+		client.SetMessageHandler(handler)
+		if err := client.Subscribe("acks", mqttc.SubscribeQoS(1)); err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		if err := client.Publish("acks", "x", mqttc.PublishQoS(1)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		<-started
+
+		// give an early PUBACK time to arrive before checking it did or did not
+		time.Sleep(50 * time.Millisecond)
+		if n := b.count(packets.PUBACK); n != tc.whileHandle {
+			t.Errorf("ack timing %d: broker has %d PUBACKs while the handler runs; want %d", tc.ack, n, tc.whileHandle)
+		}
+		close(release)
+		<-received
+		waitFor(t, func() bool { return b.count(packets.PUBACK) == 1 })
+		client.Disconnect()
+	}
+}
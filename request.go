@@ -0,0 +1,219 @@
+package mqttc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"slices"
+
+	"github.com/gorunriki/mqttc/packets"
+	"github.com/gorunriki/mqttc/topic"
+)
+
+// ErrRequiresV5 is returned by features that only exist in MQTT 5.
+var ErrRequiresV5 = errors.New("requires MQTT 5")
+
+// RequestHandler answers a request received by Respond. The returned payload
+// is published to the Response Topic of the request.
+type RequestHandler func(topic string, payload []byte) ([]byte, error)
+
+// responder is a RequestHandler registered for a topic filter
+type responder struct {
+	filter  string
+	handler RequestHandler
+}
+
+// Request publishes payload to topic and waits for the reply. The message
+// carries the client's response topic (see WithResponseTopic) and a
+// Correlation Data property that the responder must copy into its answer.
+// The response topic is subscribed on the first request of each connection.
+// Replies bypass the handler queue, so Request may be called from a message
+// or request handler. Requests need MQTT 5.
+func (c *Client) Request(ctx context.Context, topic string, payload []byte, opts ...PublishOption) ([]byte, error) {
+	if c.ProtocolVersion() != packets.V5 {
+		return nil, ErrRequiresV5
+	}
+	if err := c.subscribeResponses(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	responseTopic := c.replyTopic()
+	c.nextRequest++
+	correlation := binary.BigEndian.AppendUint64(nil, c.nextRequest)
+	reply := make(chan []byte, 1)
+	c.requests[string(correlation)] = reply
+	done := c.done
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.requests, string(correlation))
+		c.mu.Unlock()
+	}()
+
+	opts = append(opts, func(p *packets.PublishPacket) {
+		props := publishProperties(p)
+		props.ResponseTopic = responseTopic
+		props.CorrelationData = correlation
+	})
+	if err := c.Publish(topic, string(payload), opts...); err != nil {
+		return nil, err
+	}
+
+	select {
+	case payload := <-reply:
+		return payload, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-done:
+		return nil, ErrNotConnected
+	}
+}
+
+// replyTopic returns the topic replies to our requests are sent to; c.mu must be held
+func (c *Client) replyTopic() string {
+	if c.responseTopic != "" {
+		return c.responseTopic
+	}
+	id := c.clientID
+	if id == "" {
+		id = c.assignedID
+	}
+	return "mqttc/responses/" + id
+}
+
+// subscribeResponses subscribes to the response topic unless that already
+// happened on the current connection
+func (c *Client) subscribeResponses() error {
+	c.mu.Lock()
+	subscribed, responseTopic := c.responsesSubscribed, c.replyTopic()
+	c.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+
+	// two concurrent first requests may both subscribe, which is harmless
+	if err := c.Subscribe(responseTopic); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.responsesSubscribed = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Respond subscribes to filter and answers every request arriving there with
+// handler. Messages without a Response Topic are passed to the message
+// handler as usual. Replies are published with QoS 0; when handler returns
+// an error no reply is sent. When the broker does not resume the session on
+// reconnect, Connect subscribes to filter again. Respond needs MQTT 5.
+func (c *Client) Respond(filter string, handler RequestHandler) error {
+	if c.ProtocolVersion() != packets.V5 {
+		return ErrRequiresV5
+	}
+	if err := topic.ValidateFilter(filter); err != nil {
+		return err
+	}
+
+	// register first so that no request slips through to the message handler
+	c.mu.Lock()
+	c.responders = append(c.responders, responder{filter: filter, handler: handler})
+	c.mu.Unlock()
+
+	return c.Subscribe(filter)
+}
+
+// restoreResponders subscribes to the filters of all responders again, for a
+// broker that started a new session without them
+func (c *Client) restoreResponders() {
+	c.mu.Lock()
+	var filters []string
+	for _, r := range c.responders {
+		if !slices.Contains(filters, r.filter) {
+			filters = append(filters, r.filter)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, filter := range filters {
+		if err := c.Subscribe(filter); err != nil {
+			c.logger.Warn("restoring responder subscription failed", "filter", filter, "error", err)
+		}
+	}
+}
+
+// dispatchReply hands a reply straight to the waiting Request, reporting
+// whether publish was one. readLoop calls it before queueing, so that a
+// Request made from a handler does not wait for its own worker.
+func (c *Client) dispatchReply(publish *packets.PublishPacket) bool {
+	props := publish.Properties
+	if c.ProtocolVersion() != packets.V5 || props == nil || props.CorrelationData == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	var reply chan []byte
+	if publish.Topic == c.replyTopic() {
+		reply = c.requests[string(props.CorrelationData)]
+	}
+	c.mu.Unlock()
+
+	if reply == nil {
+		return false
+	}
+	select {
+	case reply <- publish.Payload:
+	default: // duplicate reply, the first one wins
+	}
+	return true
+}
+
+// dispatchRequest hands requests to their responder and drops replies to
+// requests no longer waiting, reporting whether publish was consumed
+func (c *Client) dispatchRequest(publish *packets.PublishPacket) bool {
+	props := publish.Properties
+	if c.ProtocolVersion() != packets.V5 || props == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	isReply := publish.Topic == c.replyTopic()
+	var handler RequestHandler
+	if props.ResponseTopic != "" {
+		for _, r := range c.responders {
+			if topic.MatchTopic(r.filter, publish.Topic) {
+				handler = r.handler
+				break
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	switch {
+	case handler != nil:
+		c.respond(publish, handler)
+		return true
+	case isReply:
+		c.logger.Debug("dropping reply to an unknown request", "topic", publish.Topic)
+		return true
+	}
+	return false
+}
+
+// respond runs handler for a request and publishes its answer
+func (c *Client) respond(request *packets.PublishPacket, handler RequestHandler) {
+	payload, err := handler(request.Topic, request.Payload)
+	if err != nil {
+		c.logger.Warn("request handler failed, no reply sent", "topic", request.Topic, "error", err)
+		return
+	}
+
+	correlation := request.Properties.CorrelationData
+	err = c.Publish(request.Properties.ResponseTopic, string(payload), func(p *packets.PublishPacket) {
+		publishProperties(p).CorrelationData = correlation
+	})
+	if err != nil {
+		c.logger.Warn("sending reply failed", "topic", request.Properties.ResponseTopic, "error", err)
+	}
+}
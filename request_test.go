@@ -0,0 +1,148 @@
+package mqttc_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+)
+
+func TestRequestResponse(t *testing.T) {
+	b := newFakeBroker(t)
+	server := connectV5(t, b, "server")
+	defer server.Disconnect()
+	client := connectV5(t, b, "client")
+	defer client.Disconnect()
+
+	err := server.Respond("rpc/upper", func(topic string, payload []byte) ([]byte, error) {
+		if string(payload) == "fail" {
+			return nil, errors.New("refusing")
+		}
+		return []byte(strings.ToUpper(string(payload))), nil
+	})
+	if err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, word := range []string{"hello", "world"} {
+		reply, err := client.Request(ctx, "rpc/upper", []byte(word), mqttc.PublishQoS(1))
+		if err != nil {
+			t.Fatalf("Request(%q): %v", word, err)
+		}
+		if want := strings.ToUpper(word); string(reply) != want {
+			t.Errorf("Request(%q) = %q; want %q", word, reply, want)
+		}
+	}
+
+	// a failing handler sends nothing, so the request runs into its deadline
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Request(ctx, "rpc/upper", []byte("fail")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Request(fail) = %v; want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRequestNeedsV5(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "v311")
+	defer client.Disconnect()
+
+	if _, err := client.Request(context.Background(), "rpc/x", nil); !errors.Is(err, mqttc.ErrRequiresV5) {
+		t.Errorf("Request = %v; want ErrRequiresV5", err)
+	}
+	if err := client.Respond("rpc/x", nil); !errors.Is(err, mqttc.ErrRequiresV5) {
+		t.Errorf("Respond = %v; want ErrRequiresV5", err)
+	}
+}
+
+func TestRequestFromHandler(t *testing.T) {
+	b := newFakeBroker(t)
+	upper := connectV5(t, b, "upper")
+	defer upper.Disconnect()
+	server := connectV5(t, b, "server")
+	defer server.Disconnect()
+	client := connectV5(t, b, "client")
+	defer client.Disconnect()
+
+	if err := upper.Respond("rpc/upper", func(topic string, payload []byte) ([]byte, error) {
+		return []byte(strings.ToUpper(string(payload))), nil
+	}); err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+	// a responder that has to ask another one before it can answer
+	if err := server.Respond("rpc/shout", func(topic string, payload []byte) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		upper, err := server.Request(ctx, "rpc/upper", payload)
+		return append(upper, '!'), err
+	}); err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+
+	// the single handler worker is busy with the message while its request waits for the reply
+	results := make(chan string, 1)
+	client.SetMessageHandler(func(topic string, payload []byte) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		reply, err := client.Request(ctx, "rpc/shout", payload)
+		if err != nil {
+			results <- err.Error()
+			return
+		}
+		results <- string(reply)
+	})
+	if err := client.Subscribe("events"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := server.Publish("events", "hello"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case got := <-results:
+		if got != "HELLO!" {
+			t.Errorf("Request from a handler = %q; want HELLO!", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the handler")
+	}
+}
+
+func TestRespondAfterReconnect(t *testing.T) {
+	b := newFakeBroker(t)
+	server := connectV5(t, b, "server")
+	defer server.Close()
+	client := connectV5(t, b, "client")
+	defer client.Close()
+
+	err := server.Respond("rpc/echo", func(topic string, payload []byte) ([]byte, error) {
+		return payload, nil
+	})
+	if err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+
+	// the fake broker never resumes a session, the responder subscription is gone with the connection
+	b.kick(packets.ReasonServerShuttingDown)
+	for _, c := range []*mqttc.Client{server, client} {
+		waitFor(t, func() bool { return !c.IsConnected() })
+		if err := c.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	reply, err := client.Request(ctx, "rpc/echo", []byte("ping"), mqttc.PublishQoS(1))
+	if err != nil {
+		t.Fatalf("Request after reconnect: %v", err)
+	}
+	if string(reply) != "ping" {
+		t.Errorf("Request = %q; want ping", reply)
+	}
+}
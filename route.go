@@ -0,0 +1,107 @@
+package mqttc
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/gorunriki/mqttc/topic"
+)
+
+// route takes the messages matching a filter away from the message handler,
+// it backs SubscribeChan and SubscribeTyped
+type route struct {
+	filter  string
+	deliver func(msg Message, done <-chan struct{}) // done is closed when the connection goes away
+	stop    func()                                  // called once the route no longer gets messages
+	opts    []SubscribeOption                       // to subscribe again in a new session
+}
+
+// addRoute registers r and subscribes to its filter. The returned cancel
+// removes r again and unsubscribes unless another route uses the same filter.
+func (c *Client) addRoute(r *route, opts ...SubscribeOption) (func() error, error) {
+	if err := topic.ValidateFilter(r.filter); err != nil {
+		return nil, err
+	}
+
+	// register first so that no message slips through to the message handler
+	r.opts = opts
+	c.mu.Lock()
+	if c.routes == nil {
+		c.routes = make(map[string][]*route)
+	}
+	c.routes[r.filter] = append(c.routes[r.filter], r)
+	c.routeFilters.Insert(r.filter)
+	c.mu.Unlock()
+
+	if err := c.Subscribe(r.filter, opts...); err != nil {
+		c.removeRoute(r)
+		return nil, err
+	}
+
+	var once sync.Once
+	var err error
+	cancel := func() error {
+		once.Do(func() {
+			if c.removeRoute(r) && c.IsConnected() {
+				err = c.Unsubscribe(r.filter)
+			}
+		})
+		return err
+	}
+	return cancel, nil
+}
+
+// removeRoute unregisters r and stops it, reporting whether it was the last
+// route for its filter
+func (c *Client) removeRoute(r *route) bool {
+	c.mu.Lock()
+	routes := slices.DeleteFunc(c.routes[r.filter], func(other *route) bool { return other == r })
+	last := len(routes) == 0
+	if last {
+		delete(c.routes, r.filter)
+		c.routeFilters.Remove(r.filter)
+	} else {
+		c.routes[r.filter] = routes
+	}
+	c.mu.Unlock()
+
+	r.stop()
+	return last
+}
+
+// restoreRoutes subscribes to the filters of all routes again, for a broker
+// that started a new session without them
+func (c *Client) restoreRoutes() {
+	c.mu.Lock()
+	filters := make(map[string][]SubscribeOption, len(c.routes))
+	for filter, routes := range c.routes {
+		// the broker kept the options of the latest subscribe
+		filters[filter] = routes[len(routes)-1].opts
+	}
+	c.mu.Unlock()
+
+	for filter, opts := range filters {
+		if err := c.Subscribe(filter, opts...); err != nil {
+			c.logger.Warn("restoring subscription failed", "filter", filter, "error", err)
+		}
+	}
+}
+
+// dispatchRoutes hands msg to every route it matches, reporting whether
+// there was one; done is closed when the connection it arrived on ends
+func (c *Client) dispatchRoutes(msg Message, done <-chan struct{}) bool {
+	c.mu.Lock()
+	var routes []*route
+	for _, filter := range c.routeFilters.Match(msg.Topic) {
+		routes = append(routes, c.routes[filter]...)
+	}
+	c.mu.Unlock()
+
+	if len(routes) == 0 {
+		return false
+	}
+	for _, r := range routes {
+		r.deliver(msg, done)
+	}
+	return true
+}
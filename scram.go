@@ -0,0 +1,119 @@
+package mqttc
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scram is a SCRAM-SHA-256 client as described in RFC 5802 and RFC 7677
+type scram struct {
+	username string
+	password string
+
+	// state of the running exchange
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte
+}
+
+// NewSCRAMSHA256 returns an Authenticator for the SCRAM-SHA-256 method. The
+// password is never sent; both sides prove that they know it, so Finish also
+// fails when the broker cannot prove it.
+func NewSCRAMSHA256(username, password string) Authenticator {
+	return &scram{username: username, password: password}
+}
+
+func (s *scram) Method() string {
+	return "SCRAM-SHA-256"
+}
+
+// Start sends the client-first message "n,,n=user,r=nonce"
+func (s *scram) Start() ([]byte, error) {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	name := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s.username)
+	s.clientNonce = base64.StdEncoding.EncodeToString(nonce)
+	s.clientFirstBare = "n=" + name + ",r=" + s.clientNonce
+	s.serverSignature = nil
+	return []byte("n,," + s.clientFirstBare), nil
+}
+
+// Continue answers the server-first message "r=nonce,s=salt,i=iterations"
+// with the client-final message carrying our proof
+func (s *scram) Continue(challenge []byte) ([]byte, error) {
+	if s.clientFirstBare == "" {
+		return nil, errors.New("scram: challenge before the exchange started")
+	}
+	attrs := scramAttributes(string(challenge))
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, s.clientNonce) || len(nonce) == len(s.clientNonce) {
+		return nil, errors.New("scram: server nonce does not extend ours")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("scram: invalid salt")
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations < 1 {
+		return nil, errors.New("scram: invalid iteration count")
+	}
+
+	salted, err := pbkdf2.Key(sha256.New, s.password, salt, iterations, sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("scram: %w", err)
+	}
+	clientFinal := "c=biws,r=" + nonce // biws is "n,," in base64
+	authMessage := s.clientFirstBare + "," + string(challenge) + "," + clientFinal
+
+	clientKey := scramHMAC(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	proof := scramHMAC(storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	s.serverSignature = scramHMAC(scramHMAC(salted, "Server Key"), authMessage)
+
+	return []byte(clientFinal + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// Finish checks the server-final message "v=signature"
+func (s *scram) Finish(data []byte) error {
+	attrs := scramAttributes(string(data))
+	if msg, ok := attrs["e"]; ok {
+		return fmt.Errorf("scram: server reported %s", msg)
+	}
+	if s.serverSignature == nil {
+		return errors.New("scram: exchange ended before the server proved itself")
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(signature, s.serverSignature) {
+		return errors.New("scram: server signature does not match")
+	}
+	return nil
+}
+
+func scramHMAC(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// scramAttributes splits a SCRAM message into its "k=value" attributes
+func scramAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, field := range strings.Split(msg, ",") {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
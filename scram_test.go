@@ -0,0 +1,161 @@
+package mqttc_test
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// scramServer is the broker side of a SCRAM-SHA-256 exchange for a single user
+func scramServer(username, password string) brokerAuth {
+	salt := []byte("fake broker salt")
+	const iterations = 4096
+	var clientFirstBare, serverFirst string
+	step := 0
+
+	return func(data []byte) ([]byte, bool, error) {
+		step++
+		switch step {
+		case 1:
+			bare, ok := strings.CutPrefix(string(data), "n,,")
+			if !ok {
+				return nil, false, errors.New("missing GS2 header")
+			}
+			attrs := fields(bare)
+			if attrs["n"] != username {
+				return nil, false, errors.New("unknown user")
+			}
+			clientFirstBare = bare
+			serverFirst = "r=" + attrs["r"] + "srvnonce,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+			return []byte(serverFirst), false, nil
+		case 2:
+			withoutProof, encodedProof, _ := strings.Cut(string(data), ",p=")
+			proof, err := base64.StdEncoding.DecodeString(encodedProof)
+			if err != nil || len(proof) != sha256.Size {
+				return nil, false, errors.New("malformed proof")
+			}
+			salted, _ := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+			authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+
+			clientKey := mac(salted, "Client Key")
+			storedKey := sha256.Sum256(clientKey)
+			signature := mac(storedKey[:], authMessage)
+			for i := range proof {
+				proof[i] ^= signature[i]
+			}
+			if recovered := sha256.Sum256(proof); recovered != storedKey {
+				return nil, false, errors.New("wrong password")
+			}
+			serverSignature := mac(mac(salted, "Server Key"), authMessage)
+			return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), true, nil
+		}
+		return nil, false, errors.New("exchange already finished")
+	}
+}
+
+func mac(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+func fields(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, field := range strings.Split(msg, ",") {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func newSCRAMBroker(t *testing.T, password *string) *fakeBroker {
+	b := newFakeBroker(t)
+	b.authMethod = "SCRAM-SHA-256"
+	b.newAuth = func() brokerAuth {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return scramServer("alice", *password)
+	}
+	return b
+}
+
+func TestSCRAMAuthentication(t *testing.T) {
+	password := "correct horse"
+	b := newSCRAMBroker(t, &password)
+	client := connectV5(t, b, "scram", mqttc.WithAuthenticator(mqttc.NewSCRAMSHA256("alice", "correct horse")))
+	defer client.Disconnect()
+
+	if got := b.lastConnect().Properties.AuthenticationMethod; got != "SCRAM-SHA-256" {
+		t.Errorf("CONNECT authentication method = %q; want SCRAM-SHA-256", got)
+	}
+	if n := b.count(packets.AUTH); n != 1 {
+		t.Errorf("broker received %d AUTH packets during Connect; want 1", n)
+	}
+
+	if err := client.Reauthenticate(); err != nil {
+		t.Fatalf("Reauthenticate: %v", err)
+	}
+	if n := b.count(packets.AUTH); n != 3 {
+		t.Errorf("broker received %d AUTH packets after Reauthenticate; want 3", n)
+	}
+	if err := client.Publish("still/connected", "x", mqttc.PublishQoS(1)); err != nil {
+		t.Errorf("Publish after Reauthenticate: %v", err)
+	}
+
+	// once the broker no longer accepts the password, it drops the connection
+	b.mu.Lock()
+	password = "rotated"
+	b.mu.Unlock()
+	err := client.Reauthenticate()
+	var reason *mqttc.ReasonError
+	if !errors.As(err, &reason) || reason.Code != packets.ReasonNotAuthorized {
+		t.Fatalf("Reauthenticate with a stale password = %v; want not authorized", err)
+	}
+	waitFor(t, func() bool { return !client.IsConnected() })
+}
+
+func TestSCRAMWrongPassword(t *testing.T) {
+	password := "correct horse"
+	b := newSCRAMBroker(t, &password)
+	client := mqttc.NewClient(b.addr(), "scram", mqttc.WithProtocolVersion(packets.V5),
+		mqttc.WithAuthenticator(mqttc.NewSCRAMSHA256("alice", "battery staple")))
+
+	err := client.Connect()
+	var reason *mqttc.ReasonError
+	if !errors.As(err, &reason) || reason.Code != packets.ReasonNotAuthorized {
+		t.Fatalf("Connect = %v; want not authorized", err)
+	}
+}
+
+func TestSCRAMVerifiesServer(t *testing.T) {
+	password := "correct horse"
+	b := newSCRAMBroker(t, &password)
+	genuine := b.newAuth
+	b.newAuth = func() brokerAuth {
+		step := genuine()
+		return func(data []byte) ([]byte, bool, error) {
+			reply, done, err := step(data)
+			if done {
+				reply = []byte("v=" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+			}
+			return reply, done, err
+		}
+	}
+	client := mqttc.NewClient(b.addr(), "scram", mqttc.WithProtocolVersion(packets.V5),
+		mqttc.WithAuthenticator(mqttc.NewSCRAMSHA256("alice", "correct horse")))
+
+	if err := client.Connect(); err == nil || !strings.Contains(err.Error(), "server signature") {
+		t.Fatalf("Connect = %v; want a server signature error", err)
+	}
+	if client.IsConnected() {
+		t.Error("client connected to a broker that could not prove the password")
+	}
+}
@@ -0,0 +1,94 @@
+package mqttc_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc"
+)
+
+func TestSharedSubscription(t *testing.T) {
+	b := newFakeBroker(t)
+	producer := connect(t, b, "producer")
+	defer producer.Disconnect()
+
+	const workers = 3
+	received := make(chan string, 30)
+	for i := range workers {
+		worker := connect(t, b, "worker"+strconv.Itoa(i))
+		defer worker.Disconnect()
+		worker.SetMessageHandler(func(topic string, payload []byte) {
+			received <- strconv.Itoa(i) + " " + topic
+		})
+		if err := worker.SubscribeShared("workers", "jobs/+"); err != nil {
+			t.Fatalf("SubscribeShared: %v", err)
+		}
+	}
+
+	b.mu.Lock()
+	if got := b.subscribes[0].Topics[0].Topic; got != "$share/workers/jobs/+" {
+		t.Errorf("broker got filter %q; want $share/workers/jobs/+", got)
+	}
+	b.mu.Unlock()
+
+	const jobs = 9
+	for i := range jobs {
+		if err := producer.Publish("jobs/"+strconv.Itoa(i), "work"); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	// every job is handled exactly once and the load is spread over the group
+	perWorker := make(map[string]int)
+	for range jobs {
+		select {
+		case got := <-received:
+			perWorker[got[:1]]++
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out, received %v", perWorker)
+		}
+	}
+	select {
+	case got := <-received:
+		t.Errorf("job delivered more than once: %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	for i := range workers {
+		if n := perWorker[strconv.Itoa(i)]; n != jobs/workers {
+			t.Errorf("worker %d handled %d jobs; want %d", i, n, jobs/workers)
+		}
+	}
+
+	if err := producer.SubscribeShared("bad/group", "jobs/+"); err == nil {
+		t.Error("SubscribeShared with a / in the group succeeded")
+	}
+}
+
+func TestV5SharedRespond(t *testing.T) {
+	b := newFakeBroker(t)
+	server := connectV5(t, b, "server")
+	defer server.Disconnect()
+	client := connectV5(t, b, "client")
+	defer client.Disconnect()
+
+	// a responder behind a shared subscription still sees the plain topic
+	if err := server.Respond("$share/rpc/rpc/echo", func(topic string, payload []byte) ([]byte, error) {
+		return []byte(topic), nil
+	}); err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	reply, err := client.Request(ctx, "rpc/echo", nil)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if string(reply) != "rpc/echo" {
+		t.Errorf("reply = %q; want rpc/echo", reply)
+	}
+	if err := client.Subscribe("$share/g/x", mqttc.SubscribeNoLocal(true)); err == nil {
+		t.Error("shared subscription with No Local succeeded")
+	}
+}
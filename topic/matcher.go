@@ -2,11 +2,22 @@ package topic
 
 import "strings"
 
+// MatchTopic reports whether topic matches filter as described in section
+// 4.7 of the MQTT specification. A shared subscription "$share/{group}/{filter}"
+// matches like its underlying filter.
 func MatchTopic(filter, topic string) bool {
+	if _, underlying, ok := ParseShared(filter); ok {
+		filter = underlying
+	}
 	if filter == topic {
 		return true
 	}
 
+	// filters starting with a wildcard do not match topics starting with "$", like $SYS
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
 	return matchesWithWildcards(filter, topic)
 }
 
@@ -14,12 +25,16 @@ func matchesWithWildcards(filter, topic string) bool {
 	filterParts := strings.Split(filter, "/")
 	topicParts := strings.Split(topic, "/")
 
-	for i := 0; i < len(filterParts) && i < len(topicParts); i++ {
+	for i := 0; i < len(filterParts); i++ {
 		if filterParts[i] == "#" {
-			return true
+			// "#" also matches the parent level, "sport/#" matches "sport"
+			return i == len(filterParts)-1
+		}
+		if i >= len(topicParts) {
+			return false
 		}
 		if filterParts[i] == "+" {
-			continue
+			continue // matches any single level, including an empty one
 		}
 		if filterParts[i] != topicParts[i] {
 			return false
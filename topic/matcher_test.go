@@ -12,15 +12,80 @@ func TestMatchTopic(t *testing.T) {
 		topic    string
 		expected bool
 	}{
+		// exact matches
 		{"sport/tennis/player1", "sport/tennis/player1", true},
+		{"sport/tennis/player1", "sport/tennis/player2", false},
+		{"sport/tennis", "sport/tennis/player1", false},
+		{"sport/tennis/player1", "sport/tennis", false},
+		{"sport", "Sport", false},
+		{"sport", "sport/", false},
+		{"/", "/", true},
+		{"/finance", "/finance", true},
+		{"/finance", "finance", false},
+
+		// single level wildcard
 		{"sport/tennis/+", "sport/tennis/player1", true},
-		{"sport/tennis/#", "sport/tennis/player1/ranking", true},
+		{"sport/tennis/+", "sport/tennis/player2", true},
+		{"sport/tennis/+", "sport/tennis/player1/ranking", false},
 		{"sport/+/player1", "sport/tennis/player1", true},
 		{"sport/+/player1", "sport/soccer/player1", true},
 		{"sport/+/player1", "sport/tennis/player2", false},
+		{"sport/+", "sport", false},
+		{"sport/+", "sport/", true},
+		{"+", "sport", true},
+		{"+", "sport/tennis", false},
+		{"+", "/sport", false},
+		{"+/+", "/sport", true},
+		{"/+", "/sport", true},
+		{"+/+/+", "a/b/c", true},
+		{"+/b/+", "a/x/c", false},
+
+		// multi level wildcard
+		{"sport/tennis/#", "sport/tennis/player1/ranking", true},
+		{"sport/tennis/player1/#", "sport/tennis/player1", true},
+		{"sport/tennis/player1/#", "sport/tennis/player1/ranking", true},
+		{"sport/tennis/player1/#", "sport/tennis/player1/score/wimbledon", true},
+		{"sport/#", "sport", true},
+		{"sport/#", "sport/", true},
+		{"sport/#", "sports", false},
+		{"sport/#", "other/sport", false},
+		{"#", "sport", true},
+		{"#", "sport/tennis/player1", true},
+		{"#", "/", true},
+		{"+/#", "sport", true},
+		{"+/tennis/#", "sport/tennis", true},
+		{"+/tennis/#", "sport/tennis/player1", true},
+		{"+/tennis/#", "sport/soccer/player1", false},
+
+		// a "#" that is not the last level never matches
+		{"sport/#/player1", "sport/tennis/player1", false},
+
+		// empty levels
+		{"sport//player1", "sport//player1", true},
+		{"sport/+/player1", "sport//player1", true},
+		{"sport/+/player1", "sport/player1", false},
+		{"//", "//", true},
+		{"+/+/+", "//", true},
+		{"#", "//", true},
+
+		// topics starting with "$"
+		{"#", "$SYS/broker/uptime", false},
+		{"+/broker/uptime", "$SYS/broker/uptime", false},
+		{"+", "$SYS", false},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+		{"$SYS/+/uptime", "$SYS/broker/uptime", true},
+		{"$SYS/broker/uptime", "$SYS/broker/uptime", true},
+		{"$SYS/#", "$SYS", true},
+		{"sport/#", "sport/$stats", true},
+		{"sport/+", "sport/$stats", true},
+
+		// shared subscriptions match like their underlying filter
+		{"$share/group/sport/#", "sport/tennis", true},
+		{"$share/group/#", "$SYS/broker", false},
+		{"$share/group/+", "sport", true},
 	}
 	for _, tc := range test {
-		t.Run(tc.filter+"filter to"+tc.topic, func(t *testing.T) {
+		t.Run(tc.filter+" filter to "+tc.topic, func(t *testing.T) {
 			got := topic.MatchTopic(tc.filter, tc.topic)
 			if got != tc.expected {
 				t.Errorf("MatchTopic(%q, %q) = %v; want %v", tc.filter, tc.topic, got, tc.expected)
@@ -0,0 +1,33 @@
+package topic
+
+import "strings"
+
+// SharedPrefix starts the filter of a shared subscription, "$share/{group}/{filter}".
+// The broker delivers each matching message to only one member of the group.
+const SharedPrefix = "$share/"
+
+// ParseShared splits a shared subscription into its group and the underlying
+// filter. ok is false if filter is not a shared subscription, or if the group
+// is empty or contains "/", "+" or "#".
+func ParseShared(filter string) (group, underlying string, ok bool) {
+	rest, found := strings.CutPrefix(filter, SharedPrefix)
+	if !found {
+		return "", "", false
+	}
+	group, underlying, found = strings.Cut(rest, "/")
+	if !found || !ValidGroup(group) || underlying == "" {
+		return "", "", false
+	}
+	return group, underlying, true
+}
+
+// SharedFilter returns the shared subscription of group to filter.
+func SharedFilter(group, filter string) string {
+	return SharedPrefix + group + "/" + filter
+}
+
+// ValidGroup reports whether group can be used as a share name: it must be
+// non-empty and must not contain "/", "+" or "#".
+func ValidGroup(group string) bool {
+	return group != "" && !strings.ContainsAny(group, "/+#")
+}
@@ -0,0 +1,47 @@
+package topic_test
+
+import (
+	"testing"
+
+	"github.com/gorunriki/mqttc/topic"
+)
+
+func TestParseShared(t *testing.T) {
+	tests := []struct {
+		filter     string
+		group      string
+		underlying string
+		ok         bool
+	}{
+		{"$share/workers/jobs/#", "workers", "jobs/#", true},
+		{"$share/g/+", "g", "+", true},
+		{"$share/g/a/b/c", "g", "a/b/c", true},
+		{"jobs/#", "", "", false},
+		{"$share/", "", "", false},
+		{"$share/workers", "", "", false},
+		{"$share/workers/", "", "", false},
+		{"$share//jobs", "", "", false},
+		{"$share/w+/jobs", "", "", false},
+		{"$share/w#/jobs", "", "", false},
+		{"$SHARE/workers/jobs", "", "", false},
+	}
+	for _, tt := range tests {
+		group, underlying, ok := topic.ParseShared(tt.filter)
+		if group != tt.group || underlying != tt.underlying || ok != tt.ok {
+			t.Errorf("ParseShared(%q) = %q, %q, %v; want %q, %q, %v", tt.filter, group, underlying, ok, tt.group, tt.underlying, tt.ok)
+		}
+	}
+
+	if got := topic.SharedFilter("workers", "jobs/#"); got != "$share/workers/jobs/#" {
+		t.Errorf("SharedFilter = %q; want $share/workers/jobs/#", got)
+	}
+}
+
+func TestMatchShared(t *testing.T) {
+	if !topic.MatchTopic("$share/workers/jobs/+", "jobs/42") {
+		t.Error("shared subscription does not match like its underlying filter")
+	}
+	if topic.MatchTopic("$share/workers/jobs/+", "$share/workers/jobs/42") {
+		t.Error("shared subscription matched the literal $share topic")
+	}
+}
@@ -0,0 +1,117 @@
+package topic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Template is a topic pattern with named parameters such as
+// "devices/{deviceID}/sensors/{kind}". Each parameter takes up a whole level
+// and stands for the + wildcard in the subscription filter.
+type Template struct {
+	pattern string
+	filter  string
+	levels  []string // literal levels, "" where params has a name
+	params  []string // parameter name of each level, "" for literal levels
+}
+
+// ParseTemplate compiles pattern into a Template. Parameters must take up a
+// whole level and every name may be used only once.
+func ParseTemplate(pattern string) (*Template, error) {
+	t := &Template{pattern: pattern}
+	seen := make(map[string]bool)
+	filter := make([]string, 0, strings.Count(pattern, "/")+1)
+	for level := range strings.SplitSeq(pattern, "/") {
+		name, isParam := strings.CutPrefix(level, "{")
+		if isParam {
+			name, isParam = strings.CutSuffix(name, "}")
+		}
+		switch {
+		case isParam && (name == "" || strings.ContainsAny(name, "{}")):
+			return nil, fmt.Errorf("topic template %q: invalid parameter %q", pattern, level)
+		case isParam && seen[name]:
+			return nil, fmt.Errorf("topic template %q: parameter %q used twice", pattern, name)
+		case isParam:
+			seen[name] = true
+			t.levels = append(t.levels, "")
+			t.params = append(t.params, name)
+			filter = append(filter, "+")
+		case strings.ContainsAny(level, "{}"):
+			return nil, fmt.Errorf("topic template %q: parameter in level %q must take up the whole level", pattern, level)
+		default:
+			t.levels = append(t.levels, level)
+			t.params = append(t.params, "")
+			filter = append(filter, level)
+		}
+	}
+	t.filter = strings.Join(filter, "/")
+	if err := ValidateFilter(t.filter); err != nil {
+		return nil, fmt.Errorf("topic template %q: %w", pattern, err)
+	}
+	return t, nil
+}
+
+// MustParseTemplate is like ParseTemplate but panics if the pattern is invalid.
+func MustParseTemplate(pattern string) *Template {
+	t, err := ParseTemplate(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the pattern the template was parsed from.
+func (t *Template) String() string {
+	return t.pattern
+}
+
+// Filter returns the subscription filter matching every topic of the
+// template, with + in place of each parameter.
+func (t *Template) Filter() string {
+	return t.filter
+}
+
+// Match extracts the parameters from topic. ok is false if topic does not
+// match the template.
+func (t *Template) Match(topic string) (params map[string]string, ok bool) {
+	if !MatchTopic(t.filter, topic) {
+		return nil, false
+	}
+	params = make(map[string]string)
+	i := 0
+	for level := range strings.SplitSeq(topic, "/") {
+		if i == len(t.params) {
+			break // the rest was matched by a trailing #
+		}
+		if name := t.params[i]; name != "" {
+			params[name] = level
+		}
+		i++
+	}
+	return params, true
+}
+
+// Format builds a topic by filling in the parameters. Every parameter needs a
+// value, which must not contain "/", "+" or "#".
+func (t *Template) Format(params map[string]string) (string, error) {
+	levels := make([]string, len(t.levels))
+	for i, name := range t.params {
+		if name == "" {
+			levels[i] = t.levels[i]
+			continue
+		}
+		value, ok := params[name]
+		if !ok {
+			return "", fmt.Errorf("topic template %q: missing parameter %q", t.pattern, name)
+		}
+		if strings.ContainsAny(value, "/+#") {
+			return "", fmt.Errorf("topic template %q: value %q of parameter %q must not contain /, + or #", t.pattern, value, name)
+		}
+		levels[i] = value
+	}
+	topic := strings.Join(levels, "/")
+	if err := ValidateName(topic); err != nil {
+		return "", fmt.Errorf("topic template %q: %w", t.pattern, err)
+	}
+	return topic, nil
+}
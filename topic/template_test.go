@@ -0,0 +1,90 @@
+package topic_test
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/gorunriki/mqttc/topic"
+)
+
+func TestTemplate(t *testing.T) {
+	tmpl := topic.MustParseTemplate("devices/{deviceID}/sensors/{kind}")
+	if got := tmpl.Filter(); got != "devices/+/sensors/+" {
+		t.Errorf("Filter = %q; want devices/+/sensors/+", got)
+	}
+	if got := tmpl.String(); got != "devices/{deviceID}/sensors/{kind}" {
+		t.Errorf("String = %q", got)
+	}
+
+	tests := []struct {
+		topic  string
+		params map[string]string
+		ok     bool
+	}{
+		{"devices/d1/sensors/temp", map[string]string{"deviceID": "d1", "kind": "temp"}, true},
+		{"devices//sensors/", map[string]string{"deviceID": "", "kind": ""}, true},
+		{"devices/d1/sensors", nil, false},
+		{"devices/d1/sensors/temp/raw", nil, false},
+		{"devices/d1/actuators/fan", nil, false},
+	}
+	for _, tt := range tests {
+		params, ok := tmpl.Match(tt.topic)
+		if ok != tt.ok || !maps.Equal(params, tt.params) {
+			t.Errorf("Match(%q) = %v, %v; want %v, %v", tt.topic, params, ok, tt.params, tt.ok)
+		}
+	}
+
+	topicName, err := tmpl.Format(map[string]string{"deviceID": "d7", "kind": "humidity"})
+	if err != nil || topicName != "devices/d7/sensors/humidity" {
+		t.Errorf("Format = %q, %v; want devices/d7/sensors/humidity", topicName, err)
+	}
+	for _, params := range []map[string]string{
+		{"deviceID": "d7"},
+		{"deviceID": "d7", "kind": "a/b"},
+		{"deviceID": "+", "kind": "temp"},
+		{"deviceID": "d7", "kind": "#"},
+	} {
+		if got, err := tmpl.Format(params); err == nil {
+			t.Errorf("Format(%v) = %q; want an error", params, got)
+		}
+	}
+}
+
+func TestTemplateDollarTopics(t *testing.T) {
+	tmpl := topic.MustParseTemplate("{root}/broker/uptime")
+	if _, ok := tmpl.Match("$SYS/broker/uptime"); ok {
+		t.Error("template starting with a parameter matched a $ topic")
+	}
+	params, ok := topic.MustParseTemplate("$SYS/{broker}/uptime").Match("$SYS/b1/uptime")
+	if !ok || params["broker"] != "b1" {
+		t.Errorf("Match = %v, %v; want broker b1", params, ok)
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"devices/{}/x",
+		"devices/{id}/{id}",
+		"devices/id{x}",
+		"devices/{id}x",
+		"devices/{a{b}}",
+		"devices/{id",
+		"devices/+/{id}/#/x",
+		"",
+	} {
+		if _, err := topic.ParseTemplate(pattern); err == nil {
+			t.Errorf("ParseTemplate(%q) succeeded; want an error", pattern)
+		}
+	}
+
+	// plain wildcards are allowed, they are just not named
+	tmpl, err := topic.ParseTemplate("devices/{id}/#")
+	if err != nil || tmpl.Filter() != "devices/+/#" {
+		t.Fatalf("ParseTemplate with # = %v, %v", tmpl, err)
+	}
+	for _, name := range []string{"devices/d1", "devices/d1/a/b/c"} {
+		if params, ok := tmpl.Match(name); !ok || params["id"] != "d1" {
+			t.Errorf("Match(%q) = %v, %v; want id d1", name, params, ok)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package topic
+
+import (
+	"iter"
+	"strings"
+)
+
+// Tree is an index of topic filters that finds the filters matching a topic
+// in time proportional to the depth of the topic rather than the number of
+// filters. It matches exactly like MatchTopic. A Tree is not safe for
+// concurrent use; the zero value is an empty tree ready to use.
+type Tree struct {
+	root node
+	size int
+}
+
+type node struct {
+	children map[string]*node
+	filters  []string // filters ending at this node, several if shared subscriptions differ only in group
+}
+
+// Insert adds filter to the tree and reports whether it was not there yet.
+// The filter should have passed ValidateFilter.
+func (t *Tree) Insert(filter string) bool {
+	n := &t.root
+	for level := range levels(filter) {
+		child := n.children[level]
+		if child == nil {
+			if n.children == nil {
+				n.children = make(map[string]*node)
+			}
+			child = &node{}
+			n.children[level] = child
+		}
+		n = child
+	}
+	for _, f := range n.filters {
+		if f == filter {
+			return false
+		}
+	}
+	n.filters = append(n.filters, filter)
+	t.size++
+	return true
+}
+
+// Remove deletes filter from the tree and reports whether it was there.
+func (t *Tree) Remove(filter string) bool {
+	// remember the path so that nodes left empty can be pruned
+	path := []*node{&t.root}
+	var names []string
+	for level := range levels(filter) {
+		child := path[len(path)-1].children[level]
+		if child == nil {
+			return false
+		}
+		path = append(path, child)
+		names = append(names, level)
+	}
+
+	n := path[len(path)-1]
+	i := -1
+	for j, f := range n.filters {
+		if f == filter {
+			i = j
+		}
+	}
+	if i < 0 {
+		return false
+	}
+	n.filters = append(n.filters[:i], n.filters[i+1:]...)
+	t.size--
+
+	for j := len(path) - 1; j > 0 && len(path[j].filters) == 0 && len(path[j].children) == 0; j-- {
+		delete(path[j-1].children, names[j-1])
+	}
+	return true
+}
+
+// Len returns the number of filters in the tree.
+func (t *Tree) Len() int {
+	return t.size
+}
+
+// Match returns the filters matching topic, in no particular order.
+func (t *Tree) Match(topic string) []string {
+	var matches []string
+	t.root.match(topic, true, &matches)
+	return matches
+}
+
+// match collects the filters below n matching the remaining topic levels
+func (n *node) match(topic string, first bool, matches *[]string) {
+	level, rest, more := strings.Cut(topic, "/")
+
+	// filters starting with a wildcard do not match topics starting with "$"
+	wildcards := !first || !strings.HasPrefix(level, "$")
+	if wildcards {
+		if child := n.children["#"]; child != nil {
+			*matches = append(*matches, child.filters...)
+		}
+	}
+
+	visit := func(child *node) {
+		if more {
+			child.match(rest, false, matches)
+			return
+		}
+		*matches = append(*matches, child.filters...)
+		// "#" also matches the parent level, "sport/#" matches "sport"
+		if hash := child.children["#"]; hash != nil {
+			*matches = append(*matches, hash.filters...)
+		}
+	}
+	if level != "+" && level != "#" {
+		if child := n.children[level]; child != nil {
+			visit(child)
+		}
+	}
+	if wildcards {
+		if child := n.children["+"]; child != nil {
+			visit(child)
+		}
+	}
+}
+
+// levels yields the levels of the filter a subscription matches with, so
+// the underlying filter of a shared subscription
+func levels(filter string) iter.Seq[string] {
+	if _, underlying, ok := ParseShared(filter); ok {
+		filter = underlying
+	}
+	return strings.SplitSeq(filter, "/")
+}
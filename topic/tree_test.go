@@ -0,0 +1,158 @@
+package topic_test
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorunriki/mqttc/topic"
+)
+
+func TestTree(t *testing.T) {
+	var tree topic.Tree
+	for _, f := range []string{"sport/#", "sport/tennis/+", "sport/tennis/player1", "+/+/player1", "#", "$SYS/#", "$share/g/sport/+"} {
+		if !tree.Insert(f) {
+			t.Errorf("Insert(%q) = false; want true", f)
+		}
+	}
+	if tree.Insert("sport/#") {
+		t.Error("inserting a duplicate filter reported true")
+	}
+	if tree.Len() != 7 {
+		t.Errorf("Len = %d; want 7", tree.Len())
+	}
+
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"sport/tennis/player1", []string{"#", "+/+/player1", "sport/#", "sport/tennis/+", "sport/tennis/player1"}},
+		{"sport/tennis", []string{"#", "$share/g/sport/+", "sport/#"}},
+		{"sport", []string{"#", "sport/#"}},
+		{"news", []string{"#"}},
+		{"$SYS/uptime", []string{"$SYS/#"}},
+	}
+	for _, tt := range tests {
+		got := tree.Match(tt.topic)
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Match(%q) = %q; want %q", tt.topic, got, tt.want)
+		}
+	}
+
+	for _, f := range []string{"sport/#", "#", "sport/tennis/+"} {
+		if !tree.Remove(f) {
+			t.Errorf("Remove(%q) = false; want true", f)
+		}
+	}
+	if tree.Remove("sport/#") || tree.Remove("sport/tennis") || tree.Remove("never/added") {
+		t.Error("removing a filter that is not in the tree reported true")
+	}
+	got := tree.Match("sport/tennis/player1")
+	slices.Sort(got)
+	if want := []string{"+/+/player1", "sport/tennis/player1"}; !slices.Equal(got, want) {
+		t.Errorf("Match after Remove = %q; want %q", got, want)
+	}
+	if tree.Len() != 4 {
+		t.Errorf("Len after Remove = %d; want 4", tree.Len())
+	}
+}
+
+// TestTreeMatchesLinear checks the tree against MatchTopic on random filters and topics
+func TestTreeMatchesLinear(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	filters := randomFilters(r, 500)
+	var tree topic.Tree
+	for _, f := range filters {
+		tree.Insert(f)
+	}
+
+	for range 2000 {
+		name := randomTopic(r)
+		var want []string
+		for _, f := range filters {
+			if topic.MatchTopic(f, name) {
+				want = append(want, f)
+			}
+		}
+		got := tree.Match(name)
+		slices.Sort(got)
+		slices.Sort(want)
+		want = slices.Compact(want)
+		if !slices.Equal(got, want) {
+			t.Fatalf("Match(%q) = %q; MatchTopic finds %q", name, got, want)
+		}
+	}
+}
+
+var levelNames = []string{"a", "b", "c", "", "$SYS", "dev"}
+
+func randomTopic(r *rand.Rand) string {
+	levels := make([]string, 1+r.IntN(5))
+	for i := range levels {
+		levels[i] = levelNames[r.IntN(len(levelNames))]
+	}
+	return strings.Join(levels, "/")
+}
+
+func randomFilters(r *rand.Rand, n int) []string {
+	filters := make([]string, n)
+	for i := range filters {
+		levels := strings.Split(randomTopic(r), "/")
+		for j := range levels {
+			switch r.IntN(4) {
+			case 0:
+				levels[j] = "+"
+			case 1:
+				if j == len(levels)-1 {
+					levels[j] = "#"
+				}
+			}
+		}
+		filters[i] = strings.Join(levels, "/")
+	}
+	return filters
+}
+
+// gatewayFilters looks like a gateway with one subscription per device and a few wildcards
+func gatewayFilters(n int) []string {
+	filters := make([]string, 0, n)
+	for i := range n - 3 {
+		filters = append(filters, fmt.Sprintf("site/%d/device/%d/telemetry", i%100, i))
+	}
+	return append(filters, "site/+/device/+/telemetry", "site/7/#", "#")
+}
+
+func BenchmarkTreeMatch(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			var tree topic.Tree
+			for _, f := range gatewayFilters(n) {
+				tree.Insert(f)
+			}
+			b.ReportAllocs()
+			for b.Loop() {
+				tree.Match("site/42/device/1042/telemetry")
+			}
+		})
+	}
+}
+
+func BenchmarkLinearMatch(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			filters := gatewayFilters(n)
+			b.ReportAllocs()
+			for b.Loop() {
+				var matches []string
+				for _, f := range filters {
+					if topic.MatchTopic(f, "site/42/device/1042/telemetry") {
+						matches = append(matches, f)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidName   = errors.New("invalid topic name")
+	ErrInvalidFilter = errors.New("invalid topic filter")
+)
+
+// maxLen is the longest UTF-8 string an MQTT packet can carry
+const maxLen = 65535
+
+// ValidateName checks a topic name as used in PUBLISH: it must be 1 to 65535
+// bytes of UTF-8 without NUL characters and without the wildcards + and #.
+// The returned error wraps ErrInvalidName.
+func ValidateName(name string) error {
+	if err := validateString(name); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidName, err)
+	}
+	if i := strings.IndexAny(name, "+#"); i >= 0 {
+		return fmt.Errorf("%w %q: wildcard %q is only allowed in subscriptions", ErrInvalidName, name, name[i])
+	}
+	return nil
+}
+
+// ValidateFilter checks a topic filter as used in SUBSCRIBE: on top of the
+// rules for names, + must take up a whole level and # must take up the last
+// level. Shared subscriptions need a valid group and filter. The returned
+// error wraps ErrInvalidFilter.
+func ValidateFilter(filter string) error {
+	if err := validateString(filter); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+
+	underlying := filter
+	if rest, ok := strings.CutPrefix(filter, SharedPrefix); ok {
+		group, f, found := strings.Cut(rest, "/")
+		if !ValidGroup(group) {
+			return fmt.Errorf("%w %q: share group %q must be non-empty without /, + or #", ErrInvalidFilter, filter, group)
+		}
+		if !found || f == "" {
+			return fmt.Errorf("%w %q: shared subscription without a filter", ErrInvalidFilter, filter)
+		}
+		underlying = f
+	}
+
+	levels := strings.Split(underlying, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return fmt.Errorf("%w %q: # must be the last level", ErrInvalidFilter, filter)
+		case level == "#" || level == "+":
+		case strings.ContainsAny(level, "+#"):
+			return fmt.Errorf("%w %q: wildcard in level %q must take up the whole level", ErrInvalidFilter, filter, level)
+		}
+	}
+	return nil
+}
+
+// validateString checks the rules shared by topic names and filters
+func validateString(s string) error {
+	switch {
+	case s == "":
+		return errors.New("empty")
+	case len(s) > maxLen:
+		return fmt.Errorf("%d bytes long, at most %d are allowed", len(s), maxLen)
+	case !utf8.ValidString(s):
+		return fmt.Errorf("%q is not valid UTF-8", s)
+	case strings.ContainsRune(s, 0):
+		return fmt.Errorf("%q contains a NUL character", s)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package topic_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gorunriki/mqttc/topic"
+)
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"sport/tennis/player1", true},
+		{"sport", true},
+		{"/", true},
+		{"sport//player1", true},
+		{"$SYS/broker", true},
+		{"sport tennis", true},
+		{"spört", true},
+		{strings.Repeat("a", 65535), true},
+		{"", false},
+		{strings.Repeat("a", 65536), false},
+		{"sport/+", false},
+		{"sport/#", false},
+		{"sp+rt", false},
+		{"#", false},
+		{"sport\x00tennis", false},
+		{"sport\xff", false},
+	}
+	for _, tt := range tests {
+		err := topic.ValidateName(tt.name)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateName(%.20q) = %v; want nil", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, topic.ErrInvalidName) {
+			t.Errorf("ValidateName(%.20q) = %v; want ErrInvalidName", tt.name, err)
+		}
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		valid  bool
+	}{
+		{"sport/tennis/player1", true},
+		{"sport/tennis/#", true},
+		{"sport/+/player1", true},
+		{"#", true},
+		{"+", true},
+		{"+/+", true},
+		{"/+", true},
+		{"+/#", true},
+		{"sport//#", true},
+		{"$SYS/#", true},
+		{"$share/group/sport/#", true},
+		{"$share/group/+", true},
+		{strings.Repeat("a", 65535), true},
+		{"", false},
+		{strings.Repeat("a", 65536), false},
+		{"sport/#/x", false},
+		{"#/x", false},
+		{"sport#", false},
+		{"sport/tennis#", false},
+		{"sp+rt", false},
+		{"sport+", false},
+		{"sport/+tennis", false},
+		{"++", false},
+		{"sport\x00", false},
+		{"sport\xff", false},
+		{"$share/group", false},
+		{"$share/group/", false},
+		{"$share//sport", false},
+		{"$share/gr+up/sport", false},
+		{"$share/group/sport/#/x", false},
+	}
+	for _, tt := range tests {
+		err := topic.ValidateFilter(tt.filter)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateFilter(%.20q) = %v; want nil", tt.filter, err)
+		}
+		if !tt.valid && !errors.Is(err, topic.ErrInvalidFilter) {
+			t.Errorf("ValidateFilter(%.20q) = %v; want ErrInvalidFilter", tt.filter, err)
+		}
+	}
+}
+
+func TestValidateErrorMessages(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{topic.ValidateName("sport/+"), `invalid topic name "sport/+": wildcard '+' is only allowed in subscriptions`},
+		{topic.ValidateName(""), "invalid topic name: empty"},
+		{topic.ValidateFilter("sport/#/x"), `invalid topic filter "sport/#/x": # must be the last level`},
+		{topic.ValidateFilter("sp+rt"), `invalid topic filter "sp+rt": wildcard in level "sp+rt" must take up the whole level`},
+		{topic.ValidateFilter(strings.Repeat("a", 70000)), "invalid topic filter: 70000 bytes long, at most 65535 are allowed"},
+	}
+	for _, tt := range tests {
+		if tt.err == nil || tt.err.Error() != tt.want {
+			t.Errorf("error = %v; want %s", tt.err, tt.want)
+		}
+	}
+}
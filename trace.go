@@ -0,0 +1,202 @@
+package mqttc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorunriki/mqttc/packets"
+)
+
+// Direction tells a TraceHook whether a packet was sent or received.
+type Direction int
+
+const (
+	Inbound  Direction = iota // broker -> client
+	Outbound                  // client -> broker
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "->"
+	}
+	return "<-"
+}
+
+// TraceHook is called for every packet the client sends or receives. packet is
+// the decoded packet, e.g. *packets.PublishPacket, or nil if raw could not be
+// decoded. raw must not be modified or retained.
+//
+// packet and raw are passed as they are on the wire, including the user name,
+// password and authentication data of CONNECT and AUTH packets.
+type TraceHook func(dir Direction, packet any, raw []byte)
+
+// WithTraceHook installs hook to observe every packet on the wire.
+//
+// Setting the MQTTC_TRACE environment variable to a non-empty value installs
+// TraceWriter(os.Stderr) on clients that have no hook of their own, so a
+// session can be captured without rebuilding the application.
+func WithTraceHook(hook TraceHook) Option {
+	return func(c *Client) {
+		c.traceHook = hook
+	}
+}
+
+// TraceWriter returns a TraceHook that writes a human readable trace to w:
+// a summary line with the decoded packet followed by a hex dump of its bytes.
+// User names, passwords and authentication data are redacted in both.
+// It is safe to share between clients.
+func TraceWriter(w io.Writer) TraceHook {
+	var mu sync.Mutex
+	return func(dir Direction, packet any, raw []byte) {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s %s (%d bytes)",
+			time.Now().Format("15:04:05.000000"), dir, packets.TypeName(packets.Type(raw)), len(raw))
+		var secrets [][]byte
+		if packet != nil {
+			b.WriteByte(' ')
+			formatValue(&b, reflect.ValueOf(packet), &secrets)
+		}
+		b.WriteByte('\n')
+		b.WriteString(hex.Dump(redact(raw, secrets)))
+
+		mu.Lock()
+		defer mu.Unlock()
+		io.WriteString(w, b.String())
+	}
+}
+
+// maxTracePayload is how many bytes of a payload the trace shows
+const maxTracePayload = 64
+
+// secretFields are the packet fields the trace never shows, MQTTC_TRACE puts
+// it in the logs of deployed binaries
+var secretFields = map[string]bool{
+	"Username":           true,
+	"Password":           true,
+	"AuthenticationData": true,
+}
+
+// formatValue writes v like %+v, but follows pointers, leaves out unset
+// optional fields and shows byte slices as quoted, truncated strings.
+// Secret fields are written as <redacted> and their values appended to secrets.
+func formatValue(b *strings.Builder, v reflect.Value, secrets *[][]byte) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			b.WriteString("<nil>")
+			return
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Struct:
+		b.WriteByte('{')
+		first := true
+		for i := range v.NumField() {
+			field := v.Field(i)
+			if !v.Type().Field(i).IsExported() || unset(field) {
+				continue
+			}
+			if !first {
+				b.WriteByte(' ')
+			}
+			first = false
+			name := v.Type().Field(i).Name
+			b.WriteString(name)
+			b.WriteByte(':')
+			if secretFields[name] {
+				b.WriteString("<redacted>")
+				if field.Kind() == reflect.String {
+					*secrets = append(*secrets, []byte(field.String()))
+				} else {
+					*secrets = append(*secrets, field.Bytes())
+				}
+				continue
+			}
+			formatValue(b, field, secrets)
+		}
+		b.WriteByte('}')
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		data := v.Bytes()
+		if len(data) > maxTracePayload {
+			fmt.Fprintf(b, "%q... (%d bytes)", data[:maxTracePayload], len(data))
+		} else {
+			fmt.Fprintf(b, "%q", data)
+		}
+	case v.Kind() == reflect.Slice:
+		b.WriteByte('[')
+		for i := range v.Len() {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			formatValue(b, v.Index(i), secrets)
+		}
+		b.WriteByte(']')
+	default:
+		fmt.Fprintf(b, "%v", v)
+	}
+}
+
+// redact returns a copy of raw with the length-prefixed secrets blanked out,
+// or raw itself if there is nothing to hide
+func redact(raw []byte, secrets [][]byte) []byte {
+	if len(secrets) == 0 {
+		return raw
+	}
+	raw = bytes.Clone(raw)
+	for _, secret := range secrets {
+		// strings and binary data are encoded with a two byte length
+		field := binary.BigEndian.AppendUint16(nil, uint16(len(secret)))
+		field = append(field, secret...)
+		for i := 0; i+len(field) <= len(raw); {
+			j := bytes.Index(raw[i:], field)
+			if j < 0 {
+				break
+			}
+			start := i + j + 2
+			for k := range secret {
+				raw[start+k] = '*'
+			}
+			i = start + len(secret)
+		}
+	}
+	return raw
+}
+
+// unset reports whether an optional field was left out of the packet
+func unset(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.String:
+		return v.Len() == 0
+	}
+	return false
+}
+
+// envTraceHook returns the hook requested through MQTTC_TRACE, if any
+func envTraceHook() TraceHook {
+	if os.Getenv("MQTTC_TRACE") == "" {
+		return nil
+	}
+	return TraceWriter(os.Stderr)
+}
+
+// trace passes a packet to the trace hook, decoding it only when a hook is installed
+func (c *Client) trace(dir Direction, raw []byte) {
+	if c.traceHook == nil {
+		return
+	}
+	packet, err := packets.Decode(raw, c.ProtocolVersion())
+	if err != nil {
+		packet = nil
+	}
+	c.traceHook(dir, packet, raw)
+}
@@ -0,0 +1,149 @@
+package mqttc_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+)
+
+func TestTraceHook(t *testing.T) {
+	type event struct {
+		dir    mqttc.Direction
+		packet any
+	}
+	var mu sync.Mutex
+	var events []event
+	hook := func(dir mqttc.Direction, packet any, raw []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event{dir, packet})
+	}
+
+	b := newFakeBroker(t)
+	client := mqttc.NewClient(b.addr(), "traced", mqttc.WithTraceHook(hook))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if err := client.Publish("trace/topic", "payload", mqttc.PublishQoS(1)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []struct {
+		dir        mqttc.Direction
+		packetType string
+	}{
+		{mqttc.Outbound, "*packets.ConnectPacket"},
+		{mqttc.Inbound, "*packets.ConnackPacket"},
+		{mqttc.Outbound, "*packets.PublishPacket"},
+		{mqttc.Inbound, "*packets.AckPacket"},
+		{mqttc.Outbound, "*packets.DisconnectPacket"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d trace events; want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		got := fmt.Sprintf("%T", events[i].packet)
+		if events[i].dir != w.dir || got != w.packetType {
+			t.Errorf("event %d = %v %s; want %v %s", i, events[i].dir, got, w.dir, w.packetType)
+		}
+	}
+	if pub := events[2].packet.(*packets.PublishPacket); pub.Topic != "trace/topic" || pub.QoS != 1 {
+		t.Errorf("traced publish = %+v", pub)
+	}
+}
+
+func TestTraceWriter(t *testing.T) {
+	var buf bytes.Buffer
+	hook := mqttc.TraceWriter(&buf)
+
+	raw := packets.EncodePublish(&packets.PublishPacket{Topic: "a/b", Payload: []byte("hi")})
+	packet, err := packets.Decode(raw, packets.V311)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	hook(mqttc.Outbound, packet, raw)
+
+	out := buf.String()
+	for _, want := range []string{"-> PUBLISH (9 bytes)", "Topic:a/b", "30 07 00 03 61 2f 62 68  69"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("trace output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestTraceWriterV5(t *testing.T) {
+	var buf bytes.Buffer
+	hook := mqttc.TraceWriter(&buf)
+
+	alias := uint16(3)
+	raw := packets.EncodePublish(&packets.PublishPacket{
+		Version:    packets.V5,
+		Topic:      "a/b",
+		Properties: &packets.Properties{TopicAlias: &alias, ContentType: "text/plain"},
+		Payload:    []byte(strings.Repeat("x", 100)),
+	})
+	packet, err := packets.Decode(raw, packets.V5)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	hook(mqttc.Inbound, packet, raw)
+
+	out := buf.String()
+	wants := []string{
+		"Properties:{ContentType:text/plain TopicAlias:3}",
+		`Payload:"` + strings.Repeat("x", 64) + `"... (100 bytes)`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(out, want) {
+			t.Errorf("trace output is missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "0x") {
+		t.Errorf("trace output shows a pointer:\n%s", out)
+	}
+}
+
+func TestTraceWriterRedactsCredentials(t *testing.T) {
+	var buf bytes.Buffer
+	hook := mqttc.TraceWriter(&buf)
+
+	raw := packets.EncodeConnect(&packets.ConnectPacket{
+		ProtocolVersion: packets.V5,
+		ClientID:        "traced",
+		UsernameFlag:    true,
+		Username:        "operator",
+		PasswordFlag:    true,
+		Password:        []byte("hunter2"),
+		Properties:      &packets.Properties{AuthenticationMethod: "SCRAM-SHA-1", AuthenticationData: []byte("n,,n=operator")},
+	})
+	packet, err := packets.Decode(raw, packets.V5)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	hook(mqttc.Outbound, packet, raw)
+
+	out := buf.String()
+	for _, secret := range []string{"operator", "hunter2", "n,,n="} {
+		if strings.Contains(out, secret) {
+			t.Errorf("trace output shows %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{"Username:<redacted>", "Password:<redacted>", "AuthenticationData:<redacted>", "ClientID:traced"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("trace output is missing %q:\n%s", want, out)
+		}
+	}
+	if !bytes.Contains(raw, []byte("hunter2")) {
+		t.Error("TraceWriter modified raw")
+	}
+}
@@ -8,7 +8,7 @@ import (
 )
 
 type WebsocketConn struct {
-	conn websocket.Conn
+	conn *websocket.Conn
 }
 
 func DialWebsocket(url string) (*WebsocketConn, error) {
@@ -16,7 +16,7 @@ func DialWebsocket(url string) (*WebsocketConn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &WebsocketConn{conn: *wsConn}, nil
+	return &WebsocketConn{conn: wsConn}, nil
 }
 
 func (w *WebsocketConn) Read(b []byte) (n int, err error) {
@@ -0,0 +1,64 @@
+package mqttc
+
+import (
+	"fmt"
+
+	"github.com/gorunriki/mqttc/codec"
+)
+
+// SubscribeTyped subscribes to filter and calls handler with every matching
+// message decoded into a T by codec. Messages that do not decode are skipped
+// and reported to the handler set by WithDecodeErrorHandler. As with
+// SubscribeChan, the messages do not reach the message handler, the
+// subscription is restored when the broker starts a new session, and cancel
+// ends it.
+func SubscribeTyped[T any](c *Client, filter string, codec codec.Codec, handler func(topic string, v T), opts ...SubscribeOption) (func() error, error) {
+	return c.addRoute(&route{
+		filter: filter,
+		deliver: func(msg Message, done <-chan struct{}) {
+			// the handler has no Message to acknowledge with AckManual
+			defer msg.Ack()
+
+			var v T
+			if err := codec.Unmarshal(msg.Payload, &v); err != nil {
+				c.decodeError(msg, fmt.Errorf("decode %T: %w", v, err))
+				return
+			}
+			handler(msg.Topic, v)
+		},
+		stop: func() {},
+	}, opts...)
+}
+
+// decodeError reports a message SubscribeTyped could not decode
+func (c *Client) decodeError(msg Message, err error) {
+	if c.decodeErrors != nil {
+		c.decodeErrors(msg, err)
+		return
+	}
+	c.logger.Warn("cannot decode message, message dropped", "topic", msg.Topic, "error", err)
+}
+
+// Publisher publishes values of type T to a topic, encoded by a codec.
+type Publisher[T any] struct {
+	client *Client
+	topic  string
+	codec  codec.Codec
+	opts   []PublishOption
+}
+
+// NewPublisher returns a Publisher for topic. The options apply to every
+// message it publishes.
+func NewPublisher[T any](c *Client, topic string, codec codec.Codec, opts ...PublishOption) *Publisher[T] {
+	return &Publisher[T]{client: c, topic: topic, codec: codec, opts: opts}
+}
+
+// Publish encodes v and publishes it, with opts applied after the
+// Publisher's own options.
+func (p *Publisher[T]) Publish(v T, opts ...PublishOption) error {
+	data, err := p.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %T: %w", v, err)
+	}
+	return p.client.Publish(p.topic, string(data), append(p.opts[:len(p.opts):len(p.opts)], opts...)...)
+}
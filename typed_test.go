@@ -0,0 +1,119 @@
+package mqttc_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/codec"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type reading struct {
+	Sensor string  `json:"sensor" cbor:"sensor"`
+	Value  float64 `json:"value" cbor:"value"`
+}
+
+func TestTypedJSON(t *testing.T) {
+	b := newFakeBroker(t)
+	decodeErrors := make(chan error, 1)
+	client := mqttc.NewClient(b.addr(), "typed", mqttc.WithDecodeErrorHandler(func(msg mqttc.Message, err error) {
+		decodeErrors <- err
+	}))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Disconnect()
+
+	received := make(chan reading, 1)
+	cancel, err := mqttc.SubscribeTyped(client, "readings/+", codec.JSON{}, func(topic string, r reading) {
+		received <- r
+	})
+	if err != nil {
+		t.Fatalf("SubscribeTyped: %v", err)
+	}
+	defer cancel()
+
+	want := reading{Sensor: "t1", Value: 21.5}
+	if err := mqttc.NewPublisher[reading](client, "readings/t1", codec.JSON{}, mqttc.PublishQoS(1)).Publish(want); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("received %+v; want %+v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	// a payload that does not decode goes to the error handler, not the handler
+	if err := client.Publish("readings/t2", "{not json"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case err := <-decodeErrors:
+		if !strings.Contains(err.Error(), "decode mqttc_test.reading") {
+			t.Errorf("decode error = %v", err)
+		}
+	case got := <-received:
+		t.Fatalf("handler called with %+v for an invalid payload", got)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the decode error")
+	}
+}
+
+func TestTypedCBOR(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "typedcbor")
+	defer client.Disconnect()
+
+	received := make(chan reading, 1)
+	if _, err := mqttc.SubscribeTyped(client, "readings/#", codec.CBOR{}, func(topic string, r reading) {
+		received <- r
+	}); err != nil {
+		t.Fatalf("SubscribeTyped: %v", err)
+	}
+	want := reading{Sensor: "t1", Value: -3}
+	if err := mqttc.NewPublisher[reading](client, "readings/t1", codec.CBOR{}).Publish(want); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("received %+v; want %+v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestTypedProtobuf(t *testing.T) {
+	b := newFakeBroker(t)
+	client := connect(t, b, "typedproto")
+	defer client.Disconnect()
+
+	received := make(chan string, 1)
+	if _, err := mqttc.SubscribeTyped(client, "names", codec.Protobuf{}, func(topic string, v *wrapperspb.StringValue) {
+		received <- v.GetValue()
+	}); err != nil {
+		t.Fatalf("SubscribeTyped: %v", err)
+	}
+	if err := mqttc.NewPublisher[*wrapperspb.StringValue](client, "names", codec.Protobuf{}).Publish(wrapperspb.String("gopher")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != "gopher" {
+			t.Errorf("received %q; want gopher", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	// values the codec cannot encode are not published
+	if err := mqttc.NewPublisher[string](client, "names", codec.Protobuf{}).Publish("plain"); err == nil {
+		t.Error("Publish of a string with the protobuf codec succeeded")
+	}
+}
@@ -0,0 +1,138 @@
+package mqttc_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gorunriki/mqttc"
+	"github.com/gorunriki/mqttc/packets"
+)
+
+func TestProtocolVersionFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   []byte
+		refuseV5 byte
+		want     byte
+		attempts []string // protocol name and level of each CONNECT
+	}{
+		{
+			name:     "MQTT 5 broker",
+			accept:   []byte{packets.V5, packets.V311},
+			want:     packets.V5,
+			attempts: []string{"MQTT/5"},
+		},
+		{
+			name:     "MQTT 3.1.1 broker",
+			accept:   []byte{packets.V311},
+			want:     packets.V311,
+			attempts: []string{"MQTT/5", "MQTT/4"},
+		},
+		{
+			name:     "MQTT 5 style refusal",
+			accept:   []byte{packets.V31},
+			refuseV5: packets.ReasonUnsupportedProtocolVersion,
+			want:     packets.V31,
+			attempts: []string{"MQTT/5", "MQTT/4", "MQIsdp/3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFakeBroker(t)
+			b.versions = tt.accept
+			b.refuseV5 = tt.refuseV5
+			client := mqttc.NewClient(b.addr(), "fallback", mqttc.WithProtocolVersions(packets.V5, packets.V311, packets.V31))
+			if err := client.Connect(); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			defer client.Disconnect()
+
+			if got := client.ProtocolVersion(); got != tt.want {
+				t.Errorf("ProtocolVersion = %d; want %d", got, tt.want)
+			}
+			b.mu.Lock()
+			var attempts []string
+			for _, c := range b.connects {
+				attempts = append(attempts, c.ProtocolName+"/"+string('0'+c.ProtocolVersion))
+			}
+			b.mu.Unlock()
+			if len(attempts) != len(tt.attempts) {
+				t.Fatalf("CONNECT attempts = %v; want %v", attempts, tt.attempts)
+			}
+			for i := range attempts {
+				if attempts[i] != tt.attempts[i] {
+					t.Errorf("CONNECT attempts = %v; want %v", attempts, tt.attempts)
+					break
+				}
+			}
+
+			// the negotiated version is used for the rest of the session
+			if err := client.Subscribe("fallback/#", mqttc.SubscribeQoS(1)); err != nil {
+				t.Errorf("Subscribe: %v", err)
+			}
+			if err := client.Publish("fallback/x", "x", mqttc.PublishQoS(2)); err != nil {
+				t.Errorf("Publish: %v", err)
+			}
+		})
+	}
+}
+
+func TestProtocolVersionExhausted(t *testing.T) {
+	b := newFakeBroker(t)
+	b.versions = []byte{packets.V5}
+	client := mqttc.NewClient(b.addr(), "old", mqttc.WithProtocolVersions(packets.V311, packets.V31))
+
+	err := client.Connect()
+	var reason *mqttc.ReasonError
+	if !errors.As(err, &reason) || reason.Code != packets.UnacceptableProtocolVersion {
+		t.Fatalf("Connect = %v; want unacceptable protocol version", err)
+	}
+	if client.IsConnected() {
+		t.Error("client connected although every version was refused")
+	}
+
+	// a 3.1.1 broker refuses MQTT 5 with a return code, not a reason code
+	b = newFakeBroker(t)
+	b.versions = []byte{packets.V311}
+	client = mqttc.NewClient(b.addr(), "new", mqttc.WithProtocolVersion(packets.V5))
+	err = client.Connect()
+	if !errors.As(err, &reason) || reason.Code != packets.UnacceptableProtocolVersion {
+		t.Fatalf("Connect = %v; want unacceptable protocol version", err)
+	}
+	if want := "connection rejected by broker: " + packets.ReturnCodeName(packets.UnacceptableProtocolVersion); err.Error() != want {
+		t.Errorf("Connect error = %q; want %q", err, want)
+	}
+}
+
+func TestProtocolVersionConcurrentReconnect(t *testing.T) {
+	b := newFakeBroker(t)
+	b.versions = []byte{packets.V311}
+	client := mqttc.NewClient(b.addr(), "racer", mqttc.WithProtocolVersions(packets.V5, packets.V311))
+
+	// the race detector flags readers that see the version change under them
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				client.ProtocolVersion()
+				client.Publish("race", "x")
+			}
+		}
+	}()
+	for range 5 {
+		if err := client.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		client.Disconnect()
+	}
+	close(stop)
+	<-done
+	if got := client.ProtocolVersion(); got != packets.V311 {
+		t.Errorf("ProtocolVersion = %d; want %d", got, packets.V311)
+	}
+}